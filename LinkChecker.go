@@ -1,87 +1,517 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
+	"mime"
 	"net/http"
+	neturl "net/url"
 	"os"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/PuerkitoBio/purell"
 	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
 )
 
 var (
-	root          = flag.String("root", "http://example.com", "Root to crawl")
-	verbose       = flag.Bool("verbose", true, "verbose")
-	debug         = flag.Bool("debug", false, "debug")
-	externalLinks = flag.Bool("externalLinks", true, "Check external links")
+	root           = flag.String("root", "http://example.com", "Root to crawl")
+	verbose        = flag.Bool("verbose", true, "verbose")
+	debug          = flag.Bool("debug", false, "debug")
+	externalLinks  = flag.Bool("externalLinks", true, "Check external links")
+	checkFavicon   = flag.Bool("checkFavicon", false, "Check that every crawled host has a favicon")
+	maxIdleTime    = flag.Duration("maxIdleTime", 0, "Abort if no fetch completes for this long (0 disables)")
+	wgTimeout      = flag.Duration("waitGroupTimeout", 0, "Abort if the crawl has not finished after this long (0 disables)")
+	checkImages    = flag.Bool("checkImages", true, "Check <img> sources")
+	maxSources     = flag.Int("maxSources", 0, "List at most this many sources per problem in text reports (0 lists all)")
+	minSeverity    = flag.String("minSeverity", severityInfo, "Leave problems less severe than this out of the report: critical, error, warning or info")
+	sortBySev      = flag.Bool("sortBySeverity", false, "List the most severe problems first")
+	format         = flag.String("format", "text", "Report format: text, json or github (workflow command annotations)")
+	groupBy        = flag.String("groupBy", "target", "Group the text report by broken target or by source page: target or source")
+	separateImgs   = flag.Bool("reportBrokenImagesSeparately", false, "List broken images in their own section of the text report, apart from broken links and other problems")
+	followCanon    = flag.Bool("followCanonical", false, "Crawl the targets of <link rel=\"canonical\">")
+	maxCanonDepth  = flag.Int("maxCanonicalDepth", 10, "Longest canonical chain followed before warning")
+	minContentLen  = flag.Int("minContentLength", 0, "Warn about internal HTML pages with bodies shorter than this many bytes")
+	maxURLLength   = flag.Int("maxUrlLength", 0, "Report internal links longer than this many characters, e.g. 2000 (0 disables)")
+	parseComments  = flag.Bool("parseComments", false, "Also check URLs found in HTML comments")
+	stripSessions  = flag.Bool("stripSessionIDs", false, "Remove well-known session IDs such as ;jsessionid= and PHPSESSID from URLs")
+	detectHomeRdr  = flag.Bool("detectHomepageRedirects", false, "Report internal links redirecting to the homepage as likely soft 404s")
+	homepage       = flag.String("homepage", "", "Homepage URL for -detectHomepageRedirects (defaults to -root)")
+	flagSchemeless = flag.Bool("flagSchemeless", false, "Warn about links that look like host names missing a scheme, like www.example.com")
+	shuffle        = flag.Bool("shuffle", false, "Crawl queued URLs in random order, to spread load across backends")
+	seed           = flag.Int64("seed", 0, "Random seed for -shuffle (0 picks one)")
+	requestDelay   = flag.String("perRequestDelay", "", "Sleep a random duration in this range before each request, e.g. 500ms-2s")
+	recheck        = flag.Bool("recheckFailures", false, "Fetch failed URLs once more after the crawl and drop those that now succeed")
+	checkAmp       = flag.Bool("checkAmp", false, "Check the AMP versions declared by <link rel=\"amphtml\">")
+	hostAliases    = flag.String("hostAliases", "", "File listing groups of host names, one group per line, to treat as the same site as the root")
+	checkManifests = flag.Bool("checkManifest", false, "Check the start_url and icons of web app manifests")
+	hashDedup      = flag.Bool("contentHashDedup", false, "Don't extract links again from pages byte identical to one already parsed")
+	strictCT       = flag.Bool("strictContentType", false, "Only parse pages whose media type is exactly text/html, reporting near misses")
+	checkAlt       = flag.Bool("checkAlt", false, "Report images without an alt attribute, and note decorative ones with alt=\"\"")
+	probeHTTPS     = flag.Bool("probeHttps", false, "Also fetch internal http URLs over https and report if only one of the two works")
+	urlInventory   = flag.String("urlInventory", "", "File listing all valid internal URLs or paths; internal links to anything else are reported without fetching them")
+	loginPattern   = flag.String("loginURLPattern", "", "Regexp matching login pages; internal links redirecting to one are reported as possible access issues")
+	followLinkHdr  = flag.Bool("followLinkHeader", false, "Check the targets of Link response headers of internal pages")
+	maxPathDepth   = flag.Int("maxPathDepth", 0, "Don't crawl internal URLs with more path segments than this, as likely crawler traps (0 disables)")
+	maxLinks       = flag.Int("maxLinksPerPage", 0, "Stop parsing a page once this many links were found in it (0 disables)")
+	maxParseTime   = flag.Duration("maxParseTime", 0, "Stop reading and parsing a page after this long (0 disables)")
+	checkTitles    = flag.Bool("checkTitles", false, "Warn about internal pages with a missing, empty or duplicate <title>")
+	listRedirects  = flag.Bool("listRedirects", false, "List internal links that redirect, with their final URL, to update them")
+	cookieFile     = flag.String("cookieFile", "", "Netscape format cookies.txt file with cookies to send")
+	maxHeaderSize  = flag.Int64("maxResponseHeaderBytes", 1<<20, "Largest response headers accepted; larger responses are reported as problems")
+	maxRedirects   = flag.Int("maxRedirects", 10, "Longest redirect chain followed from a link")
+	redirectSev    = flag.String("redirectSeverity", severityError, "Severity of exceeding -maxRedirects: error or warning")
+	drainBody      = flag.Bool("drainBodyOnSkip", true, "Read the start of unparsed response bodies so their connections can be reused")
+	idleConnTime   = flag.Duration("idleConnTimeout", 90*time.Second, "Close keep-alive connections idle for this long")
+	keepAlive      = flag.Duration("keepAlive", 30*time.Second, "Interval of TCP keep-alive probes (negative disables them)")
+	maxConns       = flag.Int("maxConnsPerHost", 0, "Connections allowed per host (0 means unlimited)")
+	schemeLimits   = flag.String("concurrencyPerScheme", "", "Connections per host allowed for each scheme, e.g. http=2,https=8, overriding -maxConnsPerHost")
+	concurrency    = flag.Int("concurrency", 1, "Number of URLs crawled at the same time")
+	maxParses      = flag.Int("maxParses", runtime.GOMAXPROCS(0), "Number of pages parsed at the same time, however many are crawled")
+	dnsTimeout     = flag.Duration("dnsTimeout", 0, "Give up resolving a host name after this long (0 disables)")
+	slowDNS        = flag.Bool("reportSlowDns", false, "Warn about hosts whose names took longer than -slowDnsThreshold to resolve")
+	slowDNSAfter   = flag.Duration("slowDnsThreshold", time.Second, "DNS resolution time above which -reportSlowDns warns about a host")
+	checkCache     = flag.Bool("checkCacheHeaders", false, "Warn about internal resources with caching headers that look misconfigured")
+	slashCheck     = flag.Bool("reportInconsistentTrailingSlash", false, "Warn about internal URLs linked to both with and without a trailing slash")
+	urlPolicy      = flag.String("urlPolicy", "", "Regexp the paths of internal link targets must match, e.g. ^[a-z0-9/-]*$; others are reported as policy violations")
+	maxExternal    = flag.Int("maxExternalChecks", 0, "Check at most this many external URLs, skipping the rest (0 means unlimited)")
+	checkEncoding  = flag.Bool("reportSourceEncoding", false, "Warn about internal pages whose declared charset disagrees with their content")
+	warmup         = flag.Bool("warmup", false, "Check that the root is reachable, accessible and HTML before crawling, failing fast otherwise")
+	checkPDFs      = flag.Bool("checkPdfLinks", false, "Check the link annotations of internal PDF documents")
+	selfRedirects  = flag.Bool("reportRedirectChainToSelf", false, "Warn about links whose redirect chain leads back to the page they are on")
+	parseSrcdoc    = flag.Bool("parseSrcdoc", false, "Also check links in the inline HTML of <iframe srcdoc> attributes")
+	domainSummary  = flag.Bool("externalDomainSummary", false, "Note how many distinct external URLs are linked on each registered domain, how many are broken and how many went unchecked")
+	validContacts  = flag.Bool("validateContacts", false, "Report malformed mailto: addresses and tel: numbers, without contacting them")
+	watch          = flag.Duration("watch", 0, "Crawl again at this interval, forever, writing the report only when the problems found change (0 crawls once)")
+	canonicalBase  = flag.Bool("resolveRelativeAgainstCanonical", false, "Resolve relative links of pages declaring a canonical URL on the same host against it, instead of the URL fetched")
+	checkViewport  = flag.Bool("checkViewport", false, "Warn about internal pages without a <meta name=\"viewport\">")
+	minInDegree    = flag.Int("minInDegree", 0, "Note internal pages linked from fewer other internal pages than this, e.g. 1 for orphans (0 disables)")
+	maxInDegree    = flag.Int("maxInDegree", 0, "Note internal pages linked from more other internal pages than this (0 disables)")
+	throttleRate   = flag.Float64("throttleOnErrorRate", 0, "Slow down while more than this fraction of recent requests fail, e.g. 0.5 (0 disables)")
+	dumpConf       = flag.Bool("dumpConfig", false, "Print the effective value of every flag as JSON and exit without crawling")
+	flagDenorm     = flag.Bool("flagDenormalizedUrls", false, "Warn about links written differently from their normalized form, like HTTP://Example.COM/")
+	maxDownload    = flag.Int64("maxDownload", 0, "Don't download bodies whose Content-Length is over this many bytes, judging them by status alone (0 disables)")
+	nfcPaths       = flag.Bool("normalizeUnicodePaths", false, "Normalize URL paths to Unicode NFC, so composed and decomposed forms of the same path are crawled once")
+	anchorRollup   = flag.Bool("reportLinksToDeletedAnchors", false, "List missing fragments in their own section of the text report, grouped by page")
+	assetsOnly     = flag.Bool("assetsOnly", false, "Only check assets: images, media, scripts and stylesheets. Internal links are followed to find pages, but not checked themselves")
+	critical5xx    = flag.Bool("reportServerErrorsAsCritical", false, "Report 5xx responses of internal URLs as critical, exiting with a distinct code")
+	maxRequests    = flag.Int("maxRequests", 0, "Stop crawling after this many HTTP requests of any kind (0 means unlimited)")
+	cacheTypes     = flag.String("cacheStaticTypes", "image/,font/,text/css,text/javascript,application/javascript", "Comma separated media type prefixes of static assets that -checkCacheHeaders expects a Cache-Control on")
 )
 
-var wg sync.WaitGroup        // outstanding fetches
-var urlq = make(chan string) // URLs to crawl
+var (
+	expectStatus     statusExpectations
+	flaggedRedirects = statusSet{}
+)
+
+func init() {
+	flag.Var(&expectStatus, "expectStatus", "PATTERN=CODE: URLs matching the regexp PATTERN must respond with CODE, e.g. /api/$=401 (repeatable)")
+	flag.Var(flaggedRedirects, "flagRedirectStatus", "Comma separated redirect status codes, e.g. 302, reported as problems where they occur (the redirects are still followed)")
+}
+
+// Exit codes.
+const (
+	exitProblems = 1 // the crawl found problems
+	exitStalled  = 3 // the crawl made no progress and was aborted
+	exitTimeout  = 4 // the crawl did not finish within -waitGroupTimeout
+	exitCritical = 5 // the crawl found critical problems
+)
+
+var wg sync.WaitGroup // outstanding fetches
+
+// exit ends the program when the crawl is stuck, os.Exit unless testing.
+var exit = os.Exit
 
 type urlFrag struct {
 	url, frag string
 }
 
+// A redirect is a 3xx response.
+type redirect struct {
+	target string
+	status int
+}
+
+// A link is a reference from the page at from to the URL to.
+type link struct {
+	to, from string
+}
+
 var (
 	mu          sync.Mutex
 	crawled     = make(map[string]bool)      // URL without fragment -> true
 	neededFrags = make(map[urlFrag][]string) // URL#frag -> who needs it
+
+	queue       []string // URLs to crawl
+	queueClosed bool
+	queueCond   = sync.NewCond(&mu)
+	shuffleRand *rand.Rand // picks the next URL under -shuffle
+
+	inFlight     = make(map[string]time.Time) // URL -> when its fetch started
+	lastProgress = time.Now()                 // when the last fetch completed
+
+	parsesActive, parsesPeak int // pages being parsed, now and at most
 )
 
-// Owned by crawlLoop goroutine:
+// parseSlots holds a token for every page being parsed, up to -maxParses.
+var parseSlots chan struct{}
+
+// loginRe is the compiled -loginURLPattern, if set.
+var loginRe *regexp.Regexp
+
+// policyRe is the compiled -urlPolicy, if set.
+var policyRe *regexp.Regexp
+
+// Bounds of -perRequestDelay.
+var delayMin, delayMax time.Duration
+
+// stateMu guards the crawl state below. A crawlLoop worker holds it while
+// crawling a URL, releasing it only while waiting on the network or
+// parsing. mu may be taken while holding it, but not the other way round.
+var stateMu sync.Mutex
+
+// Guarded by stateMu:
 var (
 	linkSources = make(map[string][]string) // url no fragment -> sources
+	linkSeen    = make(map[link]bool)       // links recorded in linkSources
+	pageLinks   = make(map[link]bool)       // those of them of kind kindLink
+	targetKind  = make(map[string]string)   // url no fragment -> kind it was first linked as
 	fragExists  = make(map[urlFrag]bool)
-	problems    []string
+	canonicalOf = make(map[string]string) // url no fragment -> its declared canonical URL
+	fetchFailed = make(map[string]bool)   // url no fragment -> fetching it failed
+
+	notInInventory = make(map[string]bool) // internal URL -> reported missing from -urlInventory
+	tooDeep        = make(map[string]bool) // internal URL -> skipped for -maxPathDepth
+
+	externalChecked = make(map[string]bool) // external URL -> counted against -maxExternalChecks
+	externalSkipped = make(map[string]bool) // external URL -> skipped for -maxExternalChecks
+
+	pagesByTitle = make(map[string][]string) // <title> -> internal pages with it
+	htmlPages    = make(map[string]bool)     // internal URL parsed as HTML -> parsed to the end
+
+	pagesByHash = make(map[[sha256.Size]byte]htmlPage) // SHA-256 of parsed page -> the page
+
+	redirectTo = make(map[string]redirect) // internal URL -> where it redirects to
+
+	checkOnly  = make(map[string]bool) // internal URL -> only linked from Link headers other than rel=next and prev, so not parsed
+	checkLater []link                  // links to checkOnly URLs, crawled once nothing else is left
+
+	linkOnlyFailures = make(map[string]error) // URL only linked to -> why crawling it failed, unreported under -assetsOnly
+
+	requestCount    int // requests made, for -maxRequests
+	requestsRefused int // requests not made for -maxRequests
+	problems        []problem
+
+	faviconChecked  = make(map[string]bool) // host -> favicon already checked
+	manifestChecked = make(map[string]bool) // manifest URL -> already checked
 )
 
-func parseHtml(httpBody io.Reader) (links []string, ids []string) {
+// htmlPage is what parseHtml extracts from a document.
+type htmlPage struct {
+	links  []string // <a href> targets
+	ids    []string // element ids, usable as #fragment targets
+	icons  []string // <link rel="icon"> targets
+	images []string // <img src> and srcset, and <picture> <source> targets
+	media  []string // <source src> targets outside <picture>, i.e. of <video> and <audio>
+	assets []string // <script src> and <link rel="stylesheet"> targets
+
+	noAlt    []string // <img src> of images without alt attribute
+	emptyAlt []string // <img src> of images with alt="", i.e. decorative
+
+	title    string // text of the first <title>, whitespace collapsed
+	hasTitle bool
+
+	truncated bool // parsing stopped at -maxLinksPerPage
+
+	canonical string // <link rel="canonical"> target, if any
+	amphtml   string // <link rel="amphtml"> target, if any
+	manifest  string // <link rel="manifest"> target, if any
+
+	hasViewport bool // has a <meta name="viewport">
+
+	charset string // declared by the first <meta charset> or <meta http-equiv="Content-Type">, if any
+}
+
+// parseHtml parses the document read from httpBody, stopping early once
+// -maxLinksPerPage links were found or ctx is done.
+func parseHtml(ctx context.Context, httpBody io.Reader) (page htmlPage) {
 	linkSeen := map[string]bool{}
-	page := html.NewTokenizer(httpBody)
+	tokenizer := html.NewTokenizer(httpBody)
+	inTitle := false
+	pictureDepth := 0
 
 	for {
-		tokenType := page.Next()
+		if *maxLinks > 0 && len(page.links) >= *maxLinks || ctx.Err() != nil {
+			page.truncated = true
+			return page
+		}
+		tokenType := tokenizer.Next()
 		if tokenType == html.ErrorToken {
-			return links, ids
+			// Reading fails once ctx is done and the body closed.
+			page.truncated = ctx.Err() != nil
+			return page
 		}
 
-		token := page.Token()
-		if tokenType == html.StartTagToken {
+		token := tokenizer.Token()
+		switch {
+		case tokenType == html.TextToken && inTitle:
+			page.title += token.Data
+		case tokenType == html.EndTagToken && token.DataAtom.String() == "title":
+			if inTitle {
+				page.title = strings.Join(strings.Fields(page.title), " ")
+			}
+			inTitle = false
+		case tokenType == html.StartTagToken && token.DataAtom.String() == "title":
+			inTitle = !page.hasTitle
+			page.hasTitle = true
+		case tokenType == html.StartTagToken && token.DataAtom.String() == "picture":
+			pictureDepth++
+		case tokenType == html.EndTagToken && token.DataAtom.String() == "picture" && pictureDepth > 0:
+			pictureDepth--
+		}
+		if tokenType == html.CommentToken && *parseComments {
+			for _, href := range commentURLs(token.Data) {
+				if !linkSeen[href] {
+					linkSeen[href] = true
+					page.links = append(page.links, href)
+				}
+			}
+		}
+		if tokenType == html.StartTagToken || tokenType == html.SelfClosingTagToken {
 			for _, attr := range token.Attr {
 				if attr.Key == "id" {
-					ids = append(links, attr.Val)
+					page.ids = append(page.ids, attr.Val)
 				}
 			}
-			if token.DataAtom.String() == "a" {
+			switch token.DataAtom.String() {
+			case "a":
 				for _, attr := range token.Attr {
 					if attr.Key == "href" {
 						href := attr.Val
 						if !linkSeen[href] {
 							linkSeen[href] = true
-							links = append(links, href)
+							page.links = append(page.links, href)
 						}
 					}
 				}
+			case "link":
+				href, ok := attrVal(token, "href")
+				if !ok {
+					break
+				}
+				if hasRel(token, "icon") {
+					page.icons = append(page.icons, href)
+				}
+				if hasRel(token, "canonical") && page.canonical == "" {
+					page.canonical = href
+				}
+				if hasRel(token, "amphtml") && page.amphtml == "" {
+					page.amphtml = href
+				}
+				if hasRel(token, "manifest") && page.manifest == "" {
+					page.manifest = href
+				}
+				if hasRel(token, "stylesheet") {
+					page.assets = append(page.assets, href)
+				}
+			case "script":
+				if src, ok := attrVal(token, "src"); ok {
+					page.assets = append(page.assets, src)
+				}
+			case "img":
+				src, hasSrc := attrVal(token, "src")
+				if hasSrc {
+					page.images = append(page.images, src)
+				}
+				if srcset, ok := attrVal(token, "srcset"); ok {
+					page.images = append(page.images, parseSrcset(srcset)...)
+				}
+				if alt, ok := attrVal(token, "alt"); !ok {
+					page.noAlt = append(page.noAlt, src)
+				} else if strings.TrimSpace(alt) == "" {
+					page.emptyAlt = append(page.emptyAlt, src)
+				}
+			case "iframe":
+				srcdoc, ok := attrVal(token, "srcdoc")
+				if !ok || !*parseSrcdoc {
+					break
+				}
+				// Its ids belong to the inline document, not this page.
+				inline := parseHtml(ctx, strings.NewReader(srcdoc))
+				for _, href := range inline.links {
+					if !linkSeen[href] {
+						linkSeen[href] = true
+						page.links = append(page.links, href)
+					}
+				}
+				page.images = append(page.images, inline.images...)
+				page.media = append(page.media, inline.media...)
+			case "meta":
+				if name, _ := attrVal(token, "name"); strings.EqualFold(name, "viewport") {
+					page.hasViewport = true
+				}
+				if page.charset != "" {
+					break
+				}
+				if cs, ok := attrVal(token, "charset"); ok {
+					page.charset = cs
+				} else if equiv, _ := attrVal(token, "http-equiv"); strings.EqualFold(equiv, "content-type") {
+					content, _ := attrVal(token, "content")
+					if _, params, err := mime.ParseMediaType(content); err == nil {
+						page.charset = params["charset"]
+					}
+				}
+			case "source":
+				var candidates []string
+				if src, ok := attrVal(token, "src"); ok {
+					candidates = append(candidates, src)
+				}
+				if srcset, ok := attrVal(token, "srcset"); ok {
+					candidates = append(candidates, parseSrcset(srcset)...)
+				}
+				if pictureDepth > 0 {
+					page.images = append(page.images, candidates...)
+				} else {
+					page.media = append(page.media, candidates...)
+				}
 			}
 		}
 
 	}
 }
 
+var (
+	commentAttrRe = regexp.MustCompile(`(?i)\b(?:href|src)\s*=\s*["']([^"']+)["']`)
+	commentURLRe  = regexp.MustCompile(`https?://[^\s"'<>()]+`)
+)
+
+// commentURLs returns the URLs in the text of an HTML comment: href and src
+// attributes of commented out markup, such as conditional comments, and
+// bare absolute URLs.
+func commentURLs(comment string) []string {
+	var urls []string
+	for _, m := range commentAttrRe.FindAllStringSubmatch(comment, -1) {
+		urls = append(urls, m[1])
+	}
+	return append(urls, commentURLRe.FindAllString(comment, -1)...)
+}
+
+// parseOnce parses the page at url read from body, unless a byte identical
+// page was parsed before. Then that page is returned as parsed, for its
+// references to be checked again from url without tokenizing it again.
+func parseOnce(ctx context.Context, url string, body io.Reader) (htmlPage, error) {
+	var b []byte
+	var err error
+	if unlocked(func() { b, err = io.ReadAll(body) }); err != nil {
+		return htmlPage{}, err
+	}
+	sum := sha256.Sum256(b)
+	if page, ok := pagesByHash[sum]; ok {
+		if *debug {
+			log.Printf("  %s was parsed before, reusing its references", url)
+		}
+		return page, nil
+	}
+	page := parsePage(ctx, bytes.NewReader(b))
+	pagesByHash[sum] = page
+	return page, nil
+}
+
+// parseSrcset returns the URLs of the image candidates in a srcset
+// attribute, like "a.png 1x, b.png 2x". Each URL runs up to the next
+// whitespace; a comma ending it also ends the candidate.
+func parseSrcset(srcset string) []string {
+	var urls []string
+	for s := srcset; ; {
+		s = strings.TrimLeft(s, ", \t\n\r\f")
+		if s == "" {
+			return urls
+		}
+		end := strings.IndexAny(s, " \t\n\r\f")
+		if end < 0 {
+			end = len(s)
+		}
+		url := s[:end]
+		s = s[end:]
+		if trimmed := strings.TrimRight(url, ","); trimmed != url {
+			url = trimmed
+		} else if i := strings.IndexByte(s, ','); i >= 0 {
+			s = s[i+1:] // skip the descriptors
+		} else {
+			s = ""
+		}
+		if url != "" {
+			urls = append(urls, url)
+		}
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// attrVal returns the value of the attribute key of token, if present.
+func attrVal(token html.Token, key string) (string, bool) {
+	for _, attr := range token.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// hasRel reports whether rel is one of the space separated values of the
+// rel attribute of token.
+func hasRel(token html.Token, rel string) bool {
+	val, _ := attrVal(token, "rel")
+	for _, r := range strings.Fields(val) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
 // url may contain a #fragment, and the fragment is then noted as needing to exist.
 func crawl(url string, sourceURL string) {
 	mu.Lock()
 	defer mu.Unlock()
-	var frag string
+	url, ok := claim(url, sourceURL)
+	if !ok {
+		return
+	}
+	wg.Add(1)
+	queue = append(queue, url)
+	queueCond.Signal()
+}
+
+// claim notes the fragment of url as needed by sourceURL, and marks url as
+// crawled. It returns url without its fragment, and whether it was not
+// claimed before. mu must be held.
+func claim(url, sourceURL string) (string, bool) {
 	if i := strings.Index(url, "#"); i >= 0 {
-		frag = url[i+1:]
+		frag := url[i+1:]
 		url = url[:i]
 		if frag != "" {
 			uf := urlFrag{url, frag}
@@ -89,38 +519,365 @@ func crawl(url string, sourceURL string) {
 		}
 	}
 	if crawled[url] {
-		return
+		return url, false
 	}
 	crawled[url] = true
+	return url, true
+}
 
-	wg.Add(1)
-	go func() {
-		urlq <- url
-	}()
+// claimRedirect claims the target of a redirect followed from sourceURL.
+func claimRedirect(target, sourceURL string) (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	return claim(target, sourceURL)
+}
+
+// nextURL waits for a URL to be queued and takes it off the queue, in
+// order unless -shuffle is set. It returns false once the queue is closed.
+func nextURL() (string, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	for len(queue) == 0 && !queueClosed {
+		queueCond.Wait()
+	}
+	if len(queue) == 0 {
+		return "", false
+	}
+	var url string
+	if shuffleRand != nil {
+		i := shuffleRand.Intn(len(queue))
+		url = queue[i]
+		queue[i] = queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+	} else {
+		url = queue[0]
+		queue = queue[1:]
+	}
+	return url, true
+}
+
+func closeQueue() {
+	mu.Lock()
+	defer mu.Unlock()
+	queueClosed = true
+	queueCond.Broadcast()
+}
+
+// kindOf returns the kind of reference url was first found as.
+func kindOf(url string) string {
+	if kind := targetKind[url]; kind != "" {
+		return kind
+	}
+	return kindLink
 }
 
 func addProblem(url, errmsg string) {
-	msg := fmt.Sprintf("Error on %s: %s (from %s)", url, errmsg, linkSources[url])
+	reportProblem(problem{Kind: kindOf(url), Severity: severityError, URL: url, Message: errmsg, Sources: linkSources[url], linked: true})
+}
+
+func addWarning(kind, url, msg string) {
+	reportProblem(problem{Kind: kind, Severity: severityWarning, URL: url, Message: msg, Sources: linkSources[url], linked: true})
+}
+
+func addNote(kind, url, msg string) {
+	reportProblem(problem{Kind: kind, Severity: severityInfo, URL: url, Message: msg, Sources: linkSources[url], linked: true})
+}
+
+// addPageWarning reports a warning about the page at url itself.
+func addPageWarning(kind, url, msg string) {
+	addPageProblem(severityWarning, kind, url, msg)
+}
+
+// addPageProblem reports a problem of the given severity about the page at
+// url itself.
+func addPageProblem(severity, kind, url, msg string) {
+	reportProblem(problem{Kind: kind, Severity: severity, URL: url, Message: msg})
+}
+
+func reportProblem(p problem) {
 	if *verbose {
-		log.Print(msg)
+		log.Print(p)
 	}
-	problems = append(problems, msg)
+	problems = append(problems, p)
 }
 
+// crawlLoop crawls queued URLs until the queue is closed. -concurrency of
+// them run at the same time, of which -maxParses may be parsing a page.
 func crawlLoop() {
-	for url := range urlq {
-		if err := doCrawl(url); err != nil {
-			addProblem(url, err.Error())
+	for {
+		url, ok := nextURL()
+		if !ok {
+			return
+		}
+		crawlURL(url)
+	}
+}
+
+// crawlURL fetches and parses the queued URL url, reporting it if that
+// fails. Under -assetsOnly, the failure of a URL only linked to so far is
+// kept, for checkRef to report if it turns out to be an asset.
+func crawlURL(url string) {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	startFetch(url)
+	err := doCrawl(url)
+	finishFetch(url)
+	if errors.Is(err, errRequestCeiling) || err == nil {
+		return
+	}
+	if *assetsOnly && targetKind[url] == kindLink {
+		linkOnlyFailures[url] = err
+		return
+	}
+	reportFetchError(url, err)
+}
+
+// reportFetchError reports that crawling url failed with err.
+func reportFetchError(url string, err error) {
+	if _, ok := err.(fetchError); ok {
+		fetchFailed[url] = true
+	}
+	var status statusError
+	if *critical5xx && isInternal(url) && errors.As(err, &status) && status.code/100 == 5 {
+		reportProblem(problem{Kind: kindOf(url), Severity: severityCritical, URL: url, Message: err.Error(), Sources: linkSources[url], linked: true})
+	} else {
+		addProblem(url, err.Error())
+	}
+}
+
+// unlocked runs f with stateMu released, for a worker to let the others
+// crawl while it waits on the network or parses.
+func unlocked(f func()) {
+	stateMu.Unlock()
+	defer stateMu.Lock()
+	f()
+}
+
+// parsePage parses the HTML read from body once one of -maxParses slots
+// is free. Like unlocked, it releases stateMu meanwhile.
+func parsePage(ctx context.Context, body io.Reader) (page htmlPage) {
+	unlocked(func() {
+		parseSlots <- struct{}{}
+		defer func() { <-parseSlots }()
+		mu.Lock()
+		parsesActive++
+		parsesPeak = max(parsesPeak, parsesActive)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			parsesActive--
+			mu.Unlock()
+		}()
+		page = parseHtml(ctx, body)
+	})
+	return page
+}
+
+func startFetch(url string) {
+	mu.Lock()
+	defer mu.Unlock()
+	inFlight[url] = time.Now()
+}
+
+func finishFetch(url string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(inFlight, url)
+	lastProgress = time.Now()
+}
+
+// watchdog aborts the crawl once no fetch has completed for maxIdle.
+func watchdog(maxIdle time.Duration) {
+	for range time.Tick(maxIdle / 4) {
+		mu.Lock()
+		idle := time.Since(lastProgress)
+		crawling := !queueClosed
+		mu.Unlock()
+		if crawling && idle >= maxIdle {
+			dumpDiagnostics(fmt.Sprintf("no fetch completed for %v", idle.Round(time.Millisecond)))
+			exit(exitStalled)
+		}
+	}
+}
+
+// awaitFetches waits for the fetches counted by wg, exiting with
+// exitTimeout if they haven't finished after -waitGroupTimeout.
+func awaitFetches(wg *sync.WaitGroup) {
+	if !waitTimeout(wg, *wgTimeout) {
+		dumpDiagnostics(fmt.Sprintf("crawl not finished after %v", *wgTimeout))
+		exit(exitTimeout)
+	}
+}
+
+// waitTimeout waits for wg, giving up after timeout unless it is zero.
+// It reports whether wg finished.
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	if timeout <= 0 {
+		wg.Wait()
+		return true
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// dumpDiagnostics logs why the crawl is stuck, the fetches still in flight
+// and the stacks of all goroutines.
+func dumpDiagnostics(reason string) {
+	log.Printf("Crawl stalled: %s", reason)
+	// Don't block on mu: it may be what everyone is stuck on.
+	if mu.TryLock() {
+		urls := make([]string, 0, len(inFlight))
+		for url := range inFlight {
+			urls = append(urls, url)
+		}
+		sort.Strings(urls)
+		for _, url := range urls {
+			log.Printf("  in flight for %v: %s", time.Since(inFlight[url]).Round(time.Millisecond), url)
 		}
+		log.Printf("  %d pages being parsed, at most %d at once", parsesActive, parsesPeak)
+		mu.Unlock()
+	} else {
+		log.Print("  mutex held, in-flight fetches unavailable")
 	}
+	pprof.Lookup("goroutine").WriteTo(log.Writer(), 2)
 }
 
 func isSpecialProtocol(ref string) bool {
-	return strings.HasPrefix(ref, "javascript:") || strings.HasPrefix(ref, "mailto:") || strings.HasPrefix(ref, "tel:")
+	return strings.HasPrefix(ref, "javascript:") || strings.HasPrefix(ref, "mailto:") || strings.HasPrefix(ref, "tel:") || strings.HasPrefix(ref, "data:")
+}
+
+// probeHTTPSVariant fetches the https version of the http URL url, and
+// warns if only one of the two works.
+func probeHTTPSVariant(url string, httpOK bool) {
+	httpsURL := "https://" + strings.TrimPrefix(url, "http://")
+	res, err := fetch(httpsURL)
+	if errors.Is(err, errRequestCeiling) {
+		return
+	}
+	var httpsStatus string
+	if err != nil {
+		httpsStatus = err.Error()
+	} else {
+		closeBody(res)
+		httpsStatus = res.Status
+	}
+	httpsOK := err == nil && res.StatusCode < 400
+	switch {
+	case httpOK && !httpsOK:
+		addPageWarning(kindScheme, url, fmt.Sprintf("works over http but not over https: %s", httpsStatus))
+	case !httpOK && httpsOK:
+		addPageWarning(kindScheme, url, "fails over http but works over https")
+	}
+}
+
+// maxDrain is how much of an unread body closeBody reads before giving up
+// on reusing the connection.
+const maxDrain = 64 << 10
+
+// closeBody closes the body of res. Unless -drainBodyOnSkip is off, what is
+// left of a small body is read first: the transport only reuses connections
+// whose body has been read to the end.
+func closeBody(res *http.Response) {
+	if *drainBody {
+		unlocked(func() { io.Copy(io.Discard, io.LimitReader(res.Body, maxDrain)) })
+	}
+	res.Body.Close()
+}
+
+// A fetchError is a failed request or unsuccessful response, as opposed to
+// a problem found in a successful response.
+type fetchError struct{ error }
+
+func (e fetchError) Unwrap() error { return e.error }
+
+// A statusError is an unexpected response status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e statusError) Error() string { return e.status }
+
+// recheckFailures fetches every URL whose fetch failed once more and drops
+// the problems of those that now succeed.
+func recheckFailures() {
+	urls := make([]string, 0, len(fetchFailed))
+	for url := range fetchFailed {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	recovered := make(map[string]bool)
+	for _, url := range urls {
+		if *verbose {
+			log.Printf("  Rechecking %s", url)
+		}
+		if recheckOK(url) {
+			recovered[url] = true
+		}
+	}
+
+	kept := problems[:0]
+	for _, p := range problems {
+		if !(recovered[p.URL] && isError(p)) {
+			kept = append(kept, p)
+		}
+	}
+	problems = kept
+}
+
+// fetchFinal GETs url, following up to -maxRedirects redirects, and
+// returns the last response.
+func fetchFinal(url string) (*http.Response, error) {
+	for hops := 0; ; hops++ {
+		res, err := fetch(url)
+		if err != nil || res.StatusCode/100 != 3 || hops >= *maxRedirects {
+			return res, err
+		}
+		next, err := res.Location()
+		closeBody(res)
+		if err != nil {
+			return nil, err
+		}
+		url = next.String()
+	}
+}
+
+// recheckOK fetches url again and reports whether it now responds as
+// expected: with the status -expectStatus expects for it, if any, or with
+// a 200 after following up to -maxRedirects redirects.
+func recheckOK(url string) bool {
+	if code, ok := expectStatus.expected(url); ok {
+		res, err := fetch(url)
+		if err != nil {
+			return false
+		}
+		closeBody(res)
+		return res.StatusCode == code
+	}
+	res, err := fetchFinal(url)
+	if err != nil {
+		return false
+	}
+	closeBody(res)
+	return res.StatusCode == 200
 }
 
-func isAbsoluteUrl(ref string) bool {
-	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+var schemelessRe = regexp.MustCompile(`(?i)^(?:www\d*\.[a-z0-9-]+(?:\.[a-z0-9-]+)*|[a-z0-9-]+(?:\.[a-z0-9-]+)*\.(?:com|org|net|edu|gov|io|co|info|biz|de|uk|fr|nl|eu))(?::\d+)?(?:[/?#]|$)`)
+
+// looksSchemeless reports whether ref, though relative, looks like it was
+// meant to be an absolute URL, e.g. www.example.com/page.
+func looksSchemeless(ref string) bool {
+	return schemelessRe.MatchString(ref)
 }
 
 func doCrawl(url string) error {
@@ -129,70 +886,278 @@ func doCrawl(url string) error {
 		log.Printf("  Crawling %s", url)
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+	res, err := fetch(url)
+	if *probeHTTPS && strings.HasPrefix(url, "http://") && isInternal(url) {
+		probeHTTPSVariant(url, err == nil && res.StatusCode < 400)
 	}
-	res, err := http.DefaultTransport.RoundTrip(req)
 	if err != nil {
-		return err
+		return fetchError{err}
+	}
+	defer func() {
+		if res != nil {
+			closeBody(res)
+		}
+	}()
+	if code, ok := expectStatus.expected(url); ok {
+		if res.StatusCode != code {
+			return fetchError{fmt.Errorf("%s, expected %d", res.Status, code)}
+		}
+		if code != 200 {
+			return nil
+		}
 	}
-	// Handle redirects.
-	if res.StatusCode/100 == 3 {
+	// Follow redirects here, so that the whole chain counts against the
+	// linked URL, origin, and its final status is reported for it. A chain
+	// reaching a URL claimed by another is still followed for its status,
+	// but the page is left to the one that owns it.
+	origin := url
+	owned := true
+	chain := map[string]bool{url: true}
+	for hops := 1; res.StatusCode/100 == 3; hops++ {
 		newURL, err := res.Location()
 		if err != nil {
 			return fmt.Errorf("resolving redirect: %v", err)
 		}
-		if !strings.HasPrefix(newURL.String(), *root) {
+		target := newURL.String()
+		if flaggedRedirects[res.StatusCode] {
+			if url == origin {
+				addProblem(origin, fmt.Sprintf("%s redirect to %s", res.Status, target))
+			} else {
+				addProblem(origin, fmt.Sprintf("%s redirect of %s to %s", res.Status, url, target))
+			}
+		}
+		if isInternal(url) {
+			redirectTo[url] = redirect{target, res.StatusCode}
+		}
+		if loginRe != nil && isInternal(url) && loginRe.MatchString(target) {
+			return fmt.Errorf("redirects to login page %s (possible access issue)", target)
+		}
+		if !isInternal(target) {
 			// Skip off-site redirects.
 			return nil
 		}
-		crawl(newURL.String(), url)
-		return nil
+		if *stripSessions {
+			target = stripSessionIDs(target)
+		}
+		if *detectHomeRdr && isHomepage(target) && !isHomepage(origin) {
+			return fmt.Errorf("redirects to the homepage %s (likely a soft 404)", target)
+		}
+		if hops > *maxRedirects {
+			msg := fmt.Sprintf("too many redirects (more than %d), the last to %s", *maxRedirects, target)
+			if *redirectSev == severityWarning {
+				addWarning(kindOf(origin), origin, msg)
+			} else {
+				addProblem(origin, msg)
+			}
+			return nil
+		}
+		target, ok := claimRedirect(target, url)
+		if chain[target] {
+			return fmt.Errorf("redirect loop back to %s", target)
+		}
+		owned = ok
+		chain[target] = true
+		if *verbose {
+			log.Printf("  Following redirect to %s", target)
+		}
+		closeBody(res)
+		if res, err = fetch(target); err != nil {
+			return fetchError{fmt.Errorf("redirected to %s: %w", target, err)}
+		}
+		url = target
+	}
+	if res.StatusCode != 200 && url != origin {
+		return fetchError{fmt.Errorf("%w, redirected to %s", statusError{res.StatusCode, res.Status}, url)}
 	}
 	if res.StatusCode != 200 {
-		return errors.New(res.Status)
+		return fetchError{statusError{res.StatusCode, res.Status}}
+	}
+	if !owned {
+		// Crawled on its own.
+		return nil
+	}
+	if *maxDownload > 0 && res.ContentLength > *maxDownload {
+		// Don't let closeBody read any of it.
+		res.Body.Close()
+		if isInternal(url) {
+			addPageProblem(severityInfo, kindContent, url, fmt.Sprintf("not downloaded, Content-Length %d is over -maxDownload", res.ContentLength))
+		}
+		return nil
 	}
 	// External links are only be checked for existance, so no further processing is needed
-	if !strings.HasPrefix(url, *root) {
+	if !isInternal(url) {
 		return nil
 	}
+	if *checkCache {
+		checkCacheHeaders(url, res.Header)
+	}
+	if checkOnly[url] {
+		return nil
+	}
+	if *followLinkHdr {
+		for _, l := range parseLinkHeader(res.Header.Values("Link")) {
+			// Hints about origins to connect to, not resources.
+			if l.hasRel("preconnect") || l.hasRel("dns-prefetch") {
+				continue
+			}
+			// Only the next and previous pages are crawled, others
+			// need only exist.
+			target, _, _ := strings.Cut(normalize(url, l.url), "#")
+			if !l.hasRel("next") && !l.hasRel("prev") && targetKind[target] == "" {
+				checkOnly[target] = true
+			}
+			checkRef(l.url, url, kindLinkHeader)
+		}
+	}
 
 	contentType := res.Header.Get("Content-Type")
 	if contentType == "" {
 		return errors.New("No Content-Type set")
 	}
+	if *checkPDFs && strings.HasPrefix(contentType, "application/pdf") {
+		var pdf []byte
+		unlocked(func() { pdf, err = io.ReadAll(res.Body) })
+		if err != nil {
+			return fetchError{err}
+		}
+		for _, ref := range pdfLinks(pdf) {
+			checkRef(ref, url, kindLink)
+		}
+		return nil
+	}
 	if !strings.HasPrefix(contentType, "text/html") {
 		return nil
 	}
+	if *strictCT {
+		if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType != "text/html" {
+			addPageWarning(kindContent, url, fmt.Sprintf("Content-Type %q is not text/html, not parsed", contentType))
+			return nil
+		}
+	}
 
-	links, ids := parseHtml(res.Body)
-	res.Body.Close()
-
-	for _, ref := range links {
-		if *debug {
-			log.Printf("  links to %s", ref)
+	ctx := context.Background()
+	if *maxParseTime > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *maxParseTime)
+		defer cancel()
+		// Closing the body ends a read waiting on a slow server.
+		stop := context.AfterFunc(ctx, func() { res.Body.Close() })
+		defer stop()
+	}
+	stopped := fmt.Sprintf("stopped parsing after -maxParseTime %v", *maxParseTime)
+	readFailed := func(err error) error {
+		if ctx.Err() != nil {
+			addPageProblem(severityInfo, kindContent, url, stopped)
+			return nil
 		}
-		if isSpecialProtocol(ref) {
-			continue
+		return fetchError{err}
+	}
+	body := &countingReader{r: res.Body}
+	var src io.Reader = body
+	var raw []byte
+	if *checkEncoding {
+		if unlocked(func() { raw, err = io.ReadAll(body) }); err != nil {
+			return readFailed(err)
+		}
+		src = bytes.NewReader(raw)
+	}
+	var page htmlPage
+	if *hashDedup {
+		if page, err = parseOnce(ctx, url, src); err != nil {
+			return readFailed(err)
 		}
-		var dest string
-		if isAbsoluteUrl(ref) {
-			dest = ref
+	} else {
+		page = parsePage(ctx, src)
+	}
+	if *checkEncoding {
+		checkSourceEncoding(url, contentType, page.charset, raw)
+	}
+	// The ids of a truncated page are not all known.
+	htmlPages[url] = !page.truncated
+	if page.truncated {
+		// Don't let closeBody read the rest of the page.
+		res.Body.Close()
+		if ctx.Err() != nil {
+			addPageProblem(severityInfo, kindContent, url, stopped)
 		} else {
-			dest = *root + ref
+			addPageProblem(severityInfo, kindContent, url, fmt.Sprintf("stopped parsing after %d links", len(page.links)))
 		}
+	} else if body.n < int64(*minContentLen) {
+		addPageWarning(kindContent, url, fmt.Sprintf("suspiciously short body (%d bytes)", body.n))
+	}
 
-		if !*externalLinks && !strings.HasPrefix(dest, *root) {
+	resolve := func(ref string) string { return ref }
+	if *canonicalBase && page.canonical != "" {
+		if base := normalize(url, page.canonical); sameHost(base, url) {
+			resolve = func(ref string) string {
+				if abs, err := resolveRef(base, ref); err == nil {
+					return abs
+				}
+				return ref
+			}
+		}
+	}
+	for _, ref := range page.links {
+		if *flagSchemeless && looksSchemeless(ref) {
+			addPageWarning(kindLink, url, fmt.Sprintf("link %q looks like a host name missing its scheme", ref))
+		}
+		if *assetsOnly {
+			discoverPage(resolve(ref), url)
 			continue
 		}
-
-		normalizedDest, _ := purell.NormalizeURLString(dest, purell.FlagsSafe)
-
-		linkSources[normalizedDest] = append(linkSources[normalizedDest], url)
-		crawl(normalizedDest, url)
+		checkLink(resolve(ref), url)
+	}
+	if *checkImages {
+		for _, src := range page.images {
+			checkRef(resolve(src), url, kindImage)
+		}
+	}
+	for _, src := range page.media {
+		checkRef(resolve(src), url, kindMedia)
+	}
+	if *assetsOnly {
+		for _, src := range page.assets {
+			checkRef(resolve(src), url, kindAsset)
+		}
+	}
+	if *checkTitles && !page.truncated {
+		if page.title == "" {
+			addPageWarning(kindTitle, url, "missing or empty <title>")
+		} else {
+			pagesByTitle[page.title] = append(pagesByTitle[page.title], url)
+		}
+	}
+	if *checkViewport && !page.hasViewport && !page.truncated {
+		addPageWarning(kindContent, url, `no <meta name="viewport">, so not mobile friendly`)
+	}
+	if *checkAlt {
+		for _, src := range page.noAlt {
+			addPageProblem(severityError, kindAlt, url, fmt.Sprintf("image %q has no alt attribute", src))
+		}
+		for _, src := range page.emptyAlt {
+			addPageProblem(severityInfo, kindAlt, url, fmt.Sprintf("image %q has an empty alt attribute, marking it decorative", src))
+		}
+	}
+	if *checkFavicon {
+		icons := make([]string, len(page.icons))
+		for i, icon := range page.icons {
+			icons[i] = resolve(icon)
+		}
+		checkFavicons(url, icons)
+	}
+	if *checkManifests && page.manifest != "" {
+		checkManifest(normalize(url, resolve(page.manifest)), url)
+	}
+	if *checkAmp && page.amphtml != "" {
+		checkRef(resolve(page.amphtml), url, kindAmp)
+	}
+	if page.canonical != "" && !isSpecialProtocol(page.canonical) {
+		canonicalOf[url] = normalize(url, page.canonical)
+		if *followCanon {
+			checkRef(page.canonical, url, kindCanonical)
+		}
 	}
-	for _, id := range ids {
+	for _, id := range page.ids {
 		if *debug {
 			log.Printf(" url %s has #%s", url, id)
 		}
@@ -201,25 +1166,775 @@ func doCrawl(url string) error {
 	return nil
 }
 
+// errRequestCeiling is returned by fetch once -maxRequests were made.
+var errRequestCeiling = errors.New("request ceiling reached")
+
+// fetch GETs url without following redirects, sending and storing cookies
+// if a cookie jar is in use. stateMu must be held; it is released while
+// waiting.
+func fetch(url string) (*http.Response, error) {
+	if *maxRequests > 0 {
+		if requestCount >= *maxRequests {
+			requestsRefused++
+			return nil, errRequestCeiling
+		}
+		requestCount++
+	}
+	if delayMax > 0 {
+		delay := delayMin + time.Duration(rand.Int63n(int64(delayMax-delayMin)+1))
+		unlocked(func() { time.Sleep(delay) })
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if jar != nil {
+		for _, c := range jar.Cookies(req.URL) {
+			req.AddCookie(c)
+		}
+	}
+	if *throttleRate > 0 {
+		throttle()
+	}
+	var res *http.Response
+	unlocked(func() { res, err = transportFor(req.URL.Scheme).RoundTrip(req) })
+	if *throttleRate > 0 {
+		recordOutcome(err != nil || res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if jar != nil {
+		jar.SetCookies(req.URL, res.Cookies())
+	}
+	return res, nil
+}
+
+// parseDelayRange parses a -perRequestDelay value: either a range like
+// "500ms-2s" or a single duration.
+func parseDelayRange(s string) (min, max time.Duration, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	lo, hi, isRange := strings.Cut(s, "-")
+	if min, err = time.ParseDuration(lo); err != nil {
+		return 0, 0, err
+	}
+	if !isRange {
+		return min, min, nil
+	}
+	if max, err = time.ParseDuration(hi); err != nil {
+		return 0, 0, err
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("bad range %q", s)
+	}
+	return min, max, nil
+}
+
+// checkLink queues ref, as found on the page at sourceURL, for crawling.
+func checkLink(ref, sourceURL string) {
+	checkRef(ref, sourceURL, kindLink)
+}
+
+// discoverPage queues the internal link ref, found on the page at
+// sourceURL, only to find more assets under -assetsOnly. Its fragment is
+// ignored, and crawlLoop doesn't report it failing.
+func discoverPage(ref, sourceURL string) {
+	if isSpecialProtocol(ref) {
+		return
+	}
+	dest := normalize(sourceURL, ref)
+	if !isInternal(dest) {
+		return
+	}
+	if i := strings.Index(dest, "#"); i >= 0 {
+		dest = dest[:i]
+	}
+	checkRef(dest, sourceURL, kindLink)
+}
+
+// checkRef queues ref, a reference of the given kind found on the page at
+// sourceURL, for crawling.
+func checkRef(ref, sourceURL, kind string) {
+	if *debug {
+		log.Printf("  links to %s", ref)
+	}
+	if isSpecialProtocol(ref) {
+		if *validContacts {
+			checkContact(ref, sourceURL)
+		}
+		return
+	}
+	normalizedDest := normalize(sourceURL, ref)
+	if *flagDenorm {
+		checkNormalized(ref, sourceURL)
+	}
+	if !*externalLinks && !isInternal(normalizedDest) {
+		return
+	}
+
+	target := addSource(normalizedDest, sourceURL)
+	if kind == kindLink {
+		pageLinks[link{target, sourceURL}] = true
+	}
+	if _, ok := targetKind[target]; !ok {
+		targetKind[target] = kind
+	} else if *assetsOnly && targetKind[target] == kindLink && kind != kindLink {
+		// Not only linked to after all, so checked after all.
+		targetKind[target] = kind
+		if err, ok := linkOnlyFailures[target]; ok {
+			delete(linkOnlyFailures, target)
+			reportFetchError(target, err)
+		}
+	}
+	if kind != kindLinkHeader {
+		delete(checkOnly, target)
+	}
+	if *maxPathDepth > 0 && isInternal(target) && pathDepth(target) > *maxPathDepth {
+		if !tooDeep[target] {
+			tooDeep[target] = true
+			addNote(kindOf(target), target, fmt.Sprintf("not crawled, more than %d path segments", *maxPathDepth))
+		}
+		return
+	}
+	if inventory != nil && isInternal(target) && !inInventory(target) {
+		if !notInInventory[target] {
+			notInInventory[target] = true
+			addProblem(target, "not in the URL inventory")
+		}
+		return
+	}
+	if *maxExternal > 0 && !isInternal(target) && !externalChecked[target] {
+		if len(externalChecked) >= *maxExternal {
+			externalSkipped[target] = true
+			return
+		}
+		externalChecked[target] = true
+	}
+	if checkOnly[target] {
+		// Wait for a link to make it a page to parse after all.
+		checkLater = append(checkLater, link{normalizedDest, sourceURL})
+		return
+	}
+	crawl(normalizedDest, sourceURL)
+}
+
+// crawlCheckLater queues the targets of checkLater, and reports whether
+// there were any.
+func crawlCheckLater() bool {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	later := checkLater
+	checkLater = nil
+	for _, l := range later {
+		crawl(l.to, l.from)
+	}
+	return len(later) > 0
+}
+
+// checkNormalized warns if ref, on the page at sourceURL, is written
+// differently from its normalized form. Relative refs are compared once
+// resolved, absolute ones as written, as parsing lower cases the scheme.
+func checkNormalized(ref, sourceURL string) {
+	r, err := neturl.Parse(ref)
+	if err != nil {
+		return
+	}
+	dest := ref
+	if !r.IsAbs() {
+		if dest, err = resolveRef(sourceURL, ref); err != nil {
+			return
+		}
+	}
+	if normalized, err := purell.NormalizeURLString(dest, purell.FlagsSafe); err == nil && normalized != dest {
+		addPageWarning(kindLink, sourceURL, fmt.Sprintf("link %q is not normalized, it is %s", ref, normalized))
+	}
+}
+
+// nfcPath returns url with its path in Unicode normalization form C, so é
+// as one code point and as e with a combining accent become the same.
+func nfcPath(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil || norm.NFC.IsNormalString(u.Path) {
+		return url
+	}
+	u.Path = norm.NFC.String(u.Path)
+	u.RawPath = ""
+	normalized, err := purell.NormalizeURLString(u.String(), purell.FlagsSafe)
+	if err != nil {
+		return u.String()
+	}
+	return normalized
+}
+
+// pathDepth returns the number of non-empty path segments of url.
+func pathDepth(url string) int {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// addSource records that the page at sourceURL links to url, once per
+// pair, and returns url without its fragment.
+func addSource(url, sourceURL string) string {
+	if i := strings.Index(url, "#"); i >= 0 {
+		url = url[:i]
+	}
+	l := link{url, sourceURL}
+	if !linkSeen[l] {
+		linkSeen[l] = true
+		linkSources[url] = append(linkSources[url], sourceURL)
+	}
+	return url
+}
+
+// checkURLLengths reports internal link targets longer than max.
+func checkURLLengths(max int) {
+	urls := make([]string, 0, len(linkSources))
+	for url := range linkSources {
+		if isInternal(url) && len(url) > max {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		addProblem(url, fmt.Sprintf("URL is %d characters long, over the limit of %d", len(url), max))
+	}
+}
+
+// checkInDegrees notes internal pages linked from fewer than min or more
+// than max other internal pages, a bound of 0 being unset. Only links
+// count, not canonicals, images or Link headers. The root and pages
+// reached through a redirect count as linked.
+func checkInDegrees(min, max int) {
+	reached := map[string]bool{*root: true}
+	for _, r := range redirectTo {
+		reached[r.target] = true
+	}
+	urls := make([]string, 0, len(htmlPages))
+	for url := range htmlPages {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		in := 0
+		for _, source := range linkSources[url] {
+			if source != url && isInternal(source) && pageLinks[link{url, source}] {
+				in++
+			}
+		}
+		switch {
+		case in == 0 && min > 0 && !reached[url]:
+			addPageProblem(severityInfo, kindGraph, url, "orphan page, not linked from any other internal page")
+		case in < min && !reached[url]:
+			addPageProblem(severityInfo, kindGraph, url, fmt.Sprintf("linked from only %d other internal pages", in))
+		case max > 0 && in > max:
+			addPageProblem(severityInfo, kindGraph, url, fmt.Sprintf("linked from %d other internal pages, more than %d", in, max))
+		}
+	}
+}
+
+// checkURLPolicy warns about internal link targets whose path doesn't
+// match -urlPolicy.
+func checkURLPolicy() {
+	var urls []string
+	for url := range linkSources {
+		if !isInternal(url) {
+			continue
+		}
+		if u, err := neturl.Parse(url); err == nil && !policyRe.MatchString(u.Path) {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		addWarning(kindPolicy, url, fmt.Sprintf("path violates the URL policy %s", policyRe))
+	}
+}
+
+// checkTrailingSlashes warns about internal URLs linked to both with and
+// without a trailing slash, reporting the variant without one.
+func checkTrailingSlashes() {
+	var urls []string
+	for url := range linkSources {
+		if slashed, ok := withTrailingSlash(url); ok && isInternal(url) && len(linkSources[slashed]) > 0 {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		slashed, _ := withTrailingSlash(url)
+		addWarning(kindLink, url, fmt.Sprintf("also linked with a trailing slash as %s, on %s", slashed, formatSources(linkSources[slashed])))
+	}
+}
+
+// withTrailingSlash returns url with a slash appended to its path, or false
+// if the path is empty or already ends in one.
+func withTrailingSlash(url string) (string, bool) {
+	u, err := neturl.Parse(url)
+	if err != nil || u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		return "", false
+	}
+	u.Path += "/"
+	u.RawPath = ""
+	return u.String(), true
+}
+
+// isHomepage reports whether url is the -homepage, or the root if unset.
+func isHomepage(url string) bool {
+	home := *homepage
+	if home == "" {
+		home = *root
+	}
+	trim := func(u string) string {
+		return strings.TrimSuffix(normalize(*root, u), "/")
+	}
+	return trim(url) == trim(home)
+}
+
+// sameHost reports whether the URLs a and b are on the same host.
+func sameHost(a, b string) bool {
+	ua, err := neturl.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := neturl.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Host, ub.Host)
+}
+
+// resolveRef resolves ref relative to the page at base.
+func resolveRef(base, ref string) (string, error) {
+	b, err := neturl.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := neturl.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// reportRedirects notes every redirecting internal URL that is linked to,
+// with the final URL of its redirect chain.
+func reportRedirects() {
+	urls := make([]string, 0, len(redirectTo))
+	for url := range redirectTo {
+		if len(linkSources[url]) > 0 {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		first := redirectTo[url]
+		final := first.target
+		for hops := 0; hops <= *maxRedirects; hops++ {
+			next, ok := redirectTo[final]
+			if !ok {
+				break
+			}
+			final = next.target
+		}
+		addNote(kindRedirect, url, fmt.Sprintf("%d redirect to %s", first.status, final))
+	}
+}
+
+// reportMissingFragments reports every #fragment linked to that doesn't
+// exist on its page, ordered by page and fragment. Only pages parsed as
+// HTML to the end are known to lack one.
+func reportMissingFragments() {
+	var missing []urlFrag
+	for uf := range neededFrags {
+		if htmlPages[uf.url] && !fragExists[uf] {
+			missing = append(missing, uf)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].url != missing[j].url {
+			return missing[i].url < missing[j].url
+		}
+		return missing[i].frag < missing[j].frag
+	})
+	for _, uf := range missing {
+		var sources []string
+		seen := make(map[string]bool)
+		for _, source := range neededFrags[uf] {
+			if !seen[source] {
+				seen[source] = true
+				sources = append(sources, source)
+			}
+		}
+		reportProblem(problem{Kind: kindFragment, Severity: severityError, URL: uf.url + "#" + uf.frag, Sources: sources})
+	}
+}
+
+// checkRedirectsToSelf warns about every page linking to an internal URL
+// whose redirect chain leads back to that page.
+func checkRedirectsToSelf() {
+	urls := make([]string, 0, len(redirectTo))
+	for url := range redirectTo {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for _, url := range urls {
+		chain := make(map[string]bool)
+		next, ok := redirectTo[url]
+		for hops := 0; ok && hops <= *maxRedirects && !chain[next.target]; hops++ {
+			chain[next.target] = true
+			next, ok = redirectTo[next.target]
+		}
+		for _, source := range linkSources[url] {
+			if chain[source] {
+				addPageWarning(kindLink, source, fmt.Sprintf("link to %s redirects back to this page", url))
+			}
+		}
+	}
+}
+
+// checkDuplicateTitles warns about every page sharing its title with
+// other pages.
+func checkDuplicateTitles() {
+	titles := make([]string, 0, len(pagesByTitle))
+	for title, pages := range pagesByTitle {
+		if len(pages) > 1 {
+			titles = append(titles, title)
+		}
+	}
+	sort.Strings(titles)
+	for _, title := range titles {
+		pages := pagesByTitle[title]
+		for i, page := range pages {
+			others := append(append([]string(nil), pages[:i]...), pages[i+1:]...)
+			addPageWarning(kindTitle, page, fmt.Sprintf("title %q is also used by %v", title, others))
+		}
+	}
+}
+
+// normalize turns ref, found on the page at base, into a normalized
+// absolute URL.
+func normalize(base, ref string) string {
+	dest, err := resolveRef(base, ref)
+	if err != nil {
+		// Leave it to the fetch to report.
+		dest = ref
+	}
+	normalizedDest, _ := purell.NormalizeURLString(dest, purell.FlagsSafe)
+	if *nfcPaths {
+		normalizedDest = nfcPath(normalizedDest)
+	}
+	if *stripSessions {
+		normalizedDest = stripSessionIDs(normalizedDest)
+	}
+	return normalizedDest
+}
+
+var sessionPathParamRe = regexp.MustCompile(`(?i);(?:jsessionid|phpsessid|sessionid|sid)=[^/;]*`)
+
+// sessionParams are query parameters known to carry session IDs. ASP
+// session cookies get a random suffix, so aspsessionid is a prefix.
+var sessionParams = []string{"phpsessid", "jsessionid", "sessionid", "session_id", "sid", "cfid", "cftoken", "aspsessionid"}
+
+// stripSessionIDs removes session IDs passed as path parameters, like
+// ;jsessionid=, or as query parameters from rawURL.
+func stripSessionIDs(rawURL string) string {
+	rest, frag, hasFrag := strings.Cut(rawURL, "#")
+	stripped, query, hasQuery := strings.Cut(rest, "?")
+	stripped = sessionPathParamRe.ReplaceAllString(stripped, "")
+	if hasQuery {
+		var kept []string
+		for _, param := range strings.Split(query, "&") {
+			key, _, _ := strings.Cut(param, "=")
+			if !isSessionParam(key) {
+				kept = append(kept, param)
+			}
+		}
+		if len(kept) > 0 {
+			stripped += "?" + strings.Join(kept, "&")
+		}
+	}
+	if hasFrag {
+		stripped += "#" + frag
+	}
+	return stripped
+}
+
+func isSessionParam(key string) bool {
+	key = strings.ToLower(key)
+	for _, p := range sessionParams {
+		if key == p || p == "aspsessionid" && strings.HasPrefix(key, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkFavicons queues the icons declared by the page at pageURL. If the
+// first page seen on a host declares none, that host's /favicon.ico is
+// fetched instead, and the host reported as having no favicon unless it
+// is found.
+func checkFavicons(pageURL string, icons []string) {
+	for _, icon := range icons {
+		checkRef(icon, pageURL, kindFavicon)
+	}
+	u, err := neturl.Parse(pageURL)
+	if err != nil || faviconChecked[u.Host] {
+		return
+	}
+	faviconChecked[u.Host] = true
+	if len(icons) > 0 {
+		return
+	}
+	faviconURL := u.Scheme + "://" + u.Host + "/favicon.ico"
+	res, err := fetchFinal(faviconURL)
+	if errors.Is(err, errRequestCeiling) {
+		return
+	}
+	var status string
+	if err != nil {
+		status = err.Error()
+	} else {
+		closeBody(res)
+		if res.StatusCode == 200 {
+			return
+		}
+		status = res.Status
+	}
+	reportProblem(problem{Kind: kindFavicon, Severity: severityError, URL: faviconURL, Message: fmt.Sprintf("host has no favicon: %s", status), Sources: []string{pageURL}})
+}
+
 func main() {
 	flag.Parse()
+	if err := configure(); err != nil {
+		log.Fatal(err)
+	}
+	if *maxIdleTime > 0 {
+		go watchdog(*maxIdleTime)
+	}
+	if *dumpConf {
+		if err := dumpConfig(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *warmup {
+		stateMu.Lock()
+		err := warmupRoot(*root)
+		stateMu.Unlock()
+		if err != nil {
+			log.Fatalf("Warmup: %v", err)
+		}
+	}
+	if *watch > 0 {
+		watchLoop(*watch)
+	}
+
+	report := runCrawl()
+	if err := writeReport(os.Stdout, report); err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(exitCode(problems))
+}
+
+// exitCode returns the exit code for a crawl that found problems, which
+// may include some filtered out of the report.
+func exitCode(problems []problem) int {
+	switch {
+	case hasCritical(problems):
+		return exitCritical
+	case hasErrors(problems):
+		return exitProblems
+	}
+	return 0
+}
+
+// configure checks the flags and sets up what they call for: transports,
+// patterns, cookies and the files listing URLs and hosts.
+func configure() error {
+	if *format != "text" && *format != "json" && *format != "github" {
+		return fmt.Errorf("Unknown -format %q", *format)
+	}
+	if *redirectSev != severityError && *redirectSev != severityWarning {
+		return fmt.Errorf("Unknown -redirectSeverity %q", *redirectSev)
+	}
+	if severityRank(*minSeverity) < 0 {
+		return fmt.Errorf("Unknown -minSeverity %q", *minSeverity)
+	}
+	if *groupBy != "target" && *groupBy != "source" {
+		return fmt.Errorf("Unknown -groupBy %q", *groupBy)
+	}
+	parseSlots = make(chan struct{}, max(*maxParses, 1))
+	if err := setupTransports(*schemeLimits); err != nil {
+		return fmt.Errorf("-concurrencyPerScheme: %v", err)
+	}
+	var err error
+	if *loginPattern != "" {
+		if loginRe, err = regexp.Compile(*loginPattern); err != nil {
+			return fmt.Errorf("-loginURLPattern: %v", err)
+		}
+	}
+	if *urlPolicy != "" {
+		if policyRe, err = regexp.Compile(*urlPolicy); err != nil {
+			return fmt.Errorf("-urlPolicy: %v", err)
+		}
+	}
+	if delayMin, delayMax, err = parseDelayRange(*requestDelay); err != nil {
+		return fmt.Errorf("-perRequestDelay: %v", err)
+	}
+	if *cookieFile != "" {
+		if err := loadCookieFile(*cookieFile); err != nil {
+			return fmt.Errorf("Loading cookies: %v", err)
+		}
+	}
+
+	if *shuffle {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		if *verbose {
+			log.Printf("Shuffling with -seed %d", *seed)
+		}
+		shuffleRand = rand.New(rand.NewSource(*seed))
+	}
 
-	go crawlLoop()
 	*root, _ = purell.NormalizeURLString(*root, purell.FlagsSafe)
+	if *urlInventory != "" {
+		if err := loadInventory(*urlInventory); err != nil {
+			return fmt.Errorf("Loading URL inventory: %v", err)
+		}
+	}
+	if *hostAliases != "" {
+		if err := loadHostAliases(*hostAliases); err != nil {
+			return fmt.Errorf("Loading host aliases: %v", err)
+		}
+	}
+	return nil
+}
+
+// runCrawl crawls the site from the root and returns the problems to
+// report.
+func runCrawl() []problem {
+	var workers sync.WaitGroup
+	for i := 0; i < max(*concurrency, 1); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			crawlLoop()
+		}()
+	}
 	crawl(*root, "")
 
-	wg.Wait()
-	close(urlq)
-	for uf, needers := range neededFrags {
-		if !fragExists[uf] {
-			problems = append(problems, fmt.Sprintf("Missing fragment for %+v from %v", uf, needers))
-		}
+	awaitFetches(&wg)
+	for crawlCheckLater() {
+		awaitFetches(&wg)
 	}
+	closeQueue()
+	workers.Wait()
 
-	for _, s := range problems {
-		fmt.Println(s)
+	stateMu.Lock()
+	defer stateMu.Unlock()
+	if *recheck {
+		recheckFailures()
+	}
+	reportMissingFragments()
+	if requestsRefused > 0 {
+		addPageProblem(severityInfo, kindLink, *root, fmt.Sprintf("request ceiling reached after %d requests, %d more not made", requestCount, requestsRefused))
+	}
+	if len(externalSkipped) > 0 {
+		addPageProblem(severityInfo, kindLink, *root, fmt.Sprintf("external check limit reached, %d external URLs beyond the first %d not checked", len(externalSkipped), *maxExternal))
+	}
+	checkCanonicalChains()
+	if *listRedirects {
+		reportRedirects()
 	}
-	if len(problems) > 0 {
-		os.Exit(1)
+	if *selfRedirects {
+		checkRedirectsToSelf()
 	}
+	if *checkTitles {
+		checkDuplicateTitles()
+	}
+	if *maxURLLength > 0 {
+		checkURLLengths(*maxURLLength)
+	}
+	if policyRe != nil {
+		checkURLPolicy()
+	}
+	if *slashCheck {
+		checkTrailingSlashes()
+	}
+	if *minInDegree > 0 || *maxInDegree > 0 {
+		checkInDegrees(*minInDegree, *maxInDegree)
+	}
+	if *slowDNS {
+		reportSlowDNS(*slowDNSAfter)
+	}
+	if *domainSummary {
+		summarizeExternalDomains()
+	}
+
+	// Pages found late in the crawl may link to URLs that had already
+	// failed by then.
+	for i, p := range problems {
+		if p.linked {
+			problems[i].Sources = linkSources[p.URL]
+		}
+	}
+
+	report := filterSeverity(problems, *minSeverity)
+	if *sortBySev {
+		sortBySeverity(report)
+	}
+	return report
+}
+
+// resetCrawl forgets everything found by a previous crawl, for -watch.
+func resetCrawl() {
+	mu.Lock()
+	crawled = make(map[string]bool)
+	neededFrags = make(map[urlFrag][]string)
+	queue = nil
+	queueClosed = false
+	inFlight = make(map[string]time.Time)
+	lastProgress = time.Now()
+	parsesActive, parsesPeak = 0, 0
+	mu.Unlock()
+
+	linkSources = make(map[string][]string)
+	linkSeen = make(map[link]bool)
+	pageLinks = make(map[link]bool)
+	targetKind = make(map[string]string)
+	fragExists = make(map[urlFrag]bool)
+	canonicalOf = make(map[string]string)
+	fetchFailed = make(map[string]bool)
+	notInInventory = make(map[string]bool)
+	tooDeep = make(map[string]bool)
+	externalChecked = make(map[string]bool)
+	externalSkipped = make(map[string]bool)
+	pagesByTitle = make(map[string][]string)
+	htmlPages = make(map[string]bool)
+	pagesByHash = make(map[[sha256.Size]byte]htmlPage)
+	redirectTo = make(map[string]redirect)
+	checkOnly = make(map[string]bool)
+	checkLater = nil
+	linkOnlyFailures = make(map[string]error)
+	requestCount, requestsRefused = 0, 0
+	problems = nil
+	faviconChecked = make(map[string]bool)
+	manifestChecked = make(map[string]bool)
+	recentFailures, throttleDelay, sinceSlowdown = nil, 0, 0
+
+	dnsMu.Lock()
+	dnsTimes = map[string]time.Duration{}
+	dnsMu.Unlock()
 }
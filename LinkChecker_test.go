@@ -0,0 +1,984 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setFlags parses args as command line flags, -verbose=false first, and
+// restores all flags and the state derived from them when t ends.
+func setFlags(t *testing.T, args ...string) {
+	t.Helper()
+	saved := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		saved[f.Name] = f.Value.String()
+	})
+	resetCrawl()
+	t.Cleanup(func() {
+		flag.VisitAll(func(f *flag.Flag) {
+			switch {
+			case f.Name == "expectStatus":
+				expectStatus = nil
+			case f.Name == "flagRedirectStatus":
+				for code := range flaggedRedirects {
+					delete(flaggedRedirects, code)
+				}
+			case f.Value.String() != saved[f.Name]:
+				f.Value.Set(saved[f.Name])
+			}
+		})
+		loginRe, policyRe, jar, shuffleRand, inventory = nil, nil, nil, nil, nil
+		internalHosts = map[string]bool{}
+		resetCrawl()
+	})
+	if err := flag.CommandLine.Parse(append([]string{"-verbose=false"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// crawlSite crawls the site served by handler from its root with the
+// command line flags args, and returns the problems reported.
+func crawlSite(t *testing.T, handler http.Handler, args ...string) []problem {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	setFlags(t, append([]string{"-root", srv.URL + "/"}, args...)...)
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	return runCrawl()
+}
+
+// site serves the given pages by path, typed by their extension and as
+// HTML if they have none. Any other path is a 404.
+type site map[string]string
+
+func (s site) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := s[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(r.URL.Path))
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	fmt.Fprint(w, body)
+}
+
+// hitCounter counts the requests a handler serves, by path and query.
+type hitCounter struct {
+	mu   sync.Mutex
+	hits map[string]int
+}
+
+func (c *hitCounter) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		if c.hits == nil {
+			c.hits = make(map[string]int)
+		}
+		c.hits[r.URL.RequestURI()]++
+		c.mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (c *hitCounter) count(path string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits[path]
+}
+
+// findProblem returns the first of problems of the given kind whose URL
+// ends in suffix.
+func findProblem(problems []problem, kind, suffix string) (problem, bool) {
+	for _, p := range problems {
+		if p.Kind == kind && strings.HasSuffix(p.URL, suffix) {
+			return p, true
+		}
+	}
+	return problem{}, false
+}
+
+func TestMissingFavicon(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":  `<a href="/a">a</a>`,
+		"/a": `<link rel="icon" href="/icon.png">`,
+	}, "-checkFavicon")
+	p, ok := findProblem(problems, kindFavicon, "/favicon.ico")
+	if !ok || !strings.HasPrefix(p.Message, "host has no favicon") {
+		t.Errorf("missing /favicon.ico not reported as a missing favicon: %v", problems)
+	}
+	if _, ok := findProblem(problems, kindFavicon, "/icon.png"); !ok {
+		t.Errorf("broken declared icon not reported: %v", problems)
+	}
+	if len(problems) != 2 {
+		t.Errorf("got %d problems, want 2: %v", len(problems), problems)
+	}
+}
+
+func TestFavicon(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":            `<a href="/a">a</a>`,
+		"/a":           `a`,
+		"/favicon.ico": ``,
+	}, "-checkFavicon")
+	if len(problems) != 0 {
+		t.Errorf("got problems for a host with a favicon: %v", problems)
+	}
+}
+
+// catchExit makes exit end the calling goroutine instead of the program,
+// sending the exit code on the returned channel, and captures the log.
+func catchExit(t *testing.T) (<-chan int, *bytes.Buffer) {
+	t.Helper()
+	codes := make(chan int, 1)
+	exit = func(code int) {
+		codes <- code
+		runtime.Goexit()
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() {
+		exit = os.Exit
+		log.SetOutput(os.Stderr)
+	})
+	return codes, &logged
+}
+
+func TestWatchdogReportsStall(t *testing.T) {
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/stall">stall</a>`})
+	mux.HandleFunc("/stall", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	defer close(release)
+	setFlags(t, "-root", srv.URL+"/")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	codes, logged := catchExit(t)
+
+	go watchdog(100 * time.Millisecond)
+	crawled := make(chan struct{})
+	go func() {
+		runCrawl()
+		close(crawled)
+	}()
+	select {
+	case code := <-codes:
+		if code != exitStalled {
+			t.Errorf("exit code %d, want %d", code, exitStalled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog didn't fire")
+	}
+	if out := logged.String(); !strings.Contains(out, "Crawl stalled") || !strings.Contains(out, "in flight") || !strings.Contains(out, srv.URL+"/stall") {
+		t.Errorf("diagnostics don't list the stalled fetch:\n%s", out)
+	}
+	release <- struct{}{}
+	<-crawled
+}
+
+func TestShortBody(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":     `<a href="/tiny">tiny</a>` + strings.Repeat(" ", 100),
+		"/tiny": `x`,
+	}, "-minContentLength", "50")
+	if len(problems) != 1 || problems[0].URL != *root+"tiny" || problems[0].Kind != kindContent {
+		t.Errorf("want only /tiny reported as too short, got %v", problems)
+	}
+}
+
+func TestLongURL(t *testing.T) {
+	long := "/" + strings.Repeat("a", 2100)
+	problems := crawlSite(t, site{
+		"/":      `<a href="` + long + `">long</a><a href="/short">short</a>`,
+		long:     ``,
+		"/short": ``,
+	}, "-maxUrlLength", "2000")
+	if len(problems) != 1 || problems[0].URL != *root+long[1:] {
+		t.Fatalf("want only the long link reported, got %v", problems)
+	}
+	if want := "over the limit of 2000"; !strings.Contains(problems[0].Message, want) {
+		t.Errorf("message %q lacks %q", problems[0].Message, want)
+	}
+}
+
+func TestParseComments(t *testing.T) {
+	pages := site{"/": `<!--[if IE]><a href="/old-ie">ie</a><![endif]--><a href="/ok">ok</a>`, "/ok": ``}
+	if problems := crawlSite(t, pages); len(problems) != 0 {
+		t.Errorf("link in a comment checked without -parseComments: %v", problems)
+	}
+	t.Run("parseComments", func(t *testing.T) {
+		problems := crawlSite(t, pages, "-parseComments")
+		if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/old-ie") {
+			t.Errorf("want the broken link in a comment reported, got %v", problems)
+		}
+	})
+}
+
+func TestStripSessionIDs(t *testing.T) {
+	var c hitCounter
+	problems := crawlSite(t, c.wrap(site{
+		"/":     `<a href="/cart;jsessionid=A1">a</a><a href="/cart;jsessionid=B2">b</a><a href="/cart">c</a>`,
+		"/cart": `<a href="/cart;jsessionid=C3?x=1">d</a>`,
+	}), "-stripSessionIDs")
+	if len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.hits) != 3 || c.hits["/cart"] != 1 || c.hits["/cart?x=1"] != 1 {
+		t.Errorf("want /, /cart and /cart?x=1 fetched once each, got %v", c.hits)
+	}
+}
+
+func TestHomepageRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/removed">removed</a><a href="/moved">moved</a>`, "/new": ``})
+	mux.Handle("/removed", http.RedirectHandler("/", http.StatusFound))
+	mux.Handle("/moved", http.RedirectHandler("/new", http.StatusFound))
+	problems := crawlSite(t, mux, "-detectHomepageRedirects")
+	if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/removed") || !strings.Contains(problems[0].Message, "soft 404") {
+		t.Errorf("want only the redirect to the homepage reported, got %v", problems)
+	}
+}
+
+func TestWaitGroupTimeout(t *testing.T) {
+	var missingDone sync.WaitGroup
+	missingDone.Add(1)
+	if waitTimeout(&missingDone, 50*time.Millisecond) {
+		t.Error("waitTimeout reported a WaitGroup missing a Done as finished")
+	}
+	missingDone.Done()
+	if !waitTimeout(&missingDone, 0) {
+		t.Error("waitTimeout reported a finished WaitGroup as unfinished")
+	}
+
+	var code int
+	exit = func(c int) { code = c }
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() {
+		exit = os.Exit
+		log.SetOutput(os.Stderr)
+	})
+	setFlags(t, "-waitGroupTimeout", "100ms")
+	var fetches sync.WaitGroup
+	fetches.Add(1)
+	defer fetches.Done()
+	awaitFetches(&fetches)
+	if code != exitTimeout {
+		t.Errorf("exit code %d, want %d", code, exitTimeout)
+	}
+	if !strings.Contains(logged.String(), "crawl not finished after 100ms") {
+		t.Errorf("no diagnostics logged:\n%s", logged.String())
+	}
+}
+
+func TestSchemelessLink(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":                `<a href="www.example.com">ex</a><a href="about.html">about</a>`,
+		"/about.html":      ``,
+		"/www.example.com": ``,
+	}, "-flagSchemeless")
+	if len(problems) != 1 || !strings.Contains(problems[0].Message, `"www.example.com" looks like a host name`) {
+		t.Errorf("want only www.example.com flagged, got %v", problems)
+	}
+	if !looksSchemeless("example.org/page") || looksSchemeless("page.html") || looksSchemeless("images/logo.png") {
+		t.Error("looksSchemeless misclassifies links")
+	}
+}
+
+func TestPerRequestDelay(t *testing.T) {
+	var mu sync.Mutex
+	var times []time.Time
+	pages := site{"/": `<a href="/a">a</a><a href="/b">b</a><a href="/c">c</a>`, "/a": ``, "/b": ``, "/c": ``}
+	crawlSite(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+		pages.ServeHTTP(w, r)
+	}), "-perRequestDelay", "50ms-100ms")
+	if len(times) != 4 {
+		t.Fatalf("got %d requests, want 4", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		// The delay comes on top of the time taken by the request itself.
+		if gap := times[i].Sub(times[i-1]); gap < 50*time.Millisecond || gap > 150*time.Millisecond {
+			t.Errorf("request %d came %v after the previous one, want 50ms to 100ms", i, gap)
+		}
+	}
+
+	for _, tc := range []struct {
+		in       string
+		min, max time.Duration
+		ok       bool
+	}{
+		{"500ms-2s", 500 * time.Millisecond, 2 * time.Second, true},
+		{"1s", time.Second, time.Second, true},
+		{"2s-1s", 0, 0, false},
+		{"fast", 0, 0, false},
+	} {
+		min, max, err := parseDelayRange(tc.in)
+		if (err == nil) != tc.ok || min != tc.min || max != tc.max {
+			t.Errorf("parseDelayRange(%q) = %v, %v, %v", tc.in, min, max, err)
+		}
+	}
+}
+
+func TestRecheckFailures(t *testing.T) {
+	var mu sync.Mutex
+	flakyHits := 0
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/flaky">flaky</a><a href="/gone">gone</a>`})
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		flakyHits++
+		first := flakyHits == 1
+		mu.Unlock()
+		if first {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	})
+	problems := crawlSite(t, mux, "-recheckFailures")
+	if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/gone") {
+		t.Errorf("want only /gone reported, got %v", problems)
+	}
+	if flakyHits != 2 {
+		t.Errorf("/flaky fetched %d times, want 2", flakyHits)
+	}
+}
+
+func TestBrokenAmpLink(t *testing.T) {
+	pages := site{"/": `<link rel="amphtml" href="amp/">`}
+	if problems := crawlSite(t, pages); len(problems) != 0 {
+		t.Errorf("amphtml checked without -checkAmp: %v", problems)
+	}
+	t.Run("checkAmp", func(t *testing.T) {
+		problems := crawlSite(t, pages, "-checkAmp")
+		if len(problems) != 1 || problems[0].Kind != kindAmp || problems[0].URL != *root+"amp/" {
+			t.Errorf("want the broken amphtml link reported, got %v", problems)
+		}
+	})
+}
+
+func TestShuffle(t *testing.T) {
+	var urls []string
+	page := ""
+	for i := 0; i < 20; i++ {
+		urls = append(urls, fmt.Sprintf("/page%d", i))
+		page += fmt.Sprintf(`<a href="/page%d">%d</a>`, i, i)
+	}
+	dequeued := func() []string {
+		setFlags(t, "-shuffle", "-seed", "42")
+		if err := configure(); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		queue = append([]string(nil), urls...)
+		mu.Unlock()
+		var order []string
+		for range urls {
+			url, _ := nextURL()
+			order = append(order, url)
+		}
+		return order
+	}
+	order := dequeued()
+	if fmt.Sprint(order) == fmt.Sprint(urls) {
+		t.Errorf("dequeued in insertion order: %v", order)
+	}
+	if again := dequeued(); fmt.Sprint(again) != fmt.Sprint(order) {
+		t.Errorf("same seed, different order: %v and %v", order, again)
+	}
+	seen := make(map[string]bool)
+	for _, url := range order {
+		seen[url] = true
+	}
+	if len(seen) != len(urls) {
+		t.Errorf("not every URL dequeued once: %v", order)
+	}
+
+	var c hitCounter
+	pages := site{"/": page}
+	for _, url := range urls {
+		pages[url] = ``
+	}
+	if problems := crawlSite(t, c.wrap(pages), "-shuffle", "-seed", "42"); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	for _, url := range urls {
+		if c.count(url) != 1 {
+			t.Errorf("%s fetched %d times", url, c.count(url))
+		}
+	}
+}
+
+func TestRedirectSeverity(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/r1">r</a>`, "/r4": ``})
+	for i := 1; i < 4; i++ {
+		mux.Handle(fmt.Sprintf("/r%d", i), http.RedirectHandler(fmt.Sprintf("/r%d", i+1), http.StatusMovedPermanently))
+	}
+	for severity, want := range map[string]int{severityError: exitProblems, severityWarning: 0} {
+		t.Run(severity, func(t *testing.T) {
+			crawlSite(t, mux, "-maxRedirects", "2", "-redirectSeverity", severity)
+			if len(problems) != 1 || !strings.Contains(problems[0].Message, "too many redirects") {
+				t.Fatalf("want the redirect chain reported, got %v", problems)
+			}
+			if got := exitCode(problems); got != want {
+				t.Errorf("exit code %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestContentHashDedup(t *testing.T) {
+	dup := `<a href="/shared">shared</a><a href="y">y</a>`
+	pages := site{"/": `<a href="/a/x">a</a><a href="/b/x">b</a>`, "/a/x": dup, "/b/x": dup, "/shared": ``, "/a/y": ``}
+	for _, tc := range []struct {
+		flag   string
+		hashed int // distinct pages kept for reuse: /, the copies, the empty ones
+	}{{"-contentHashDedup=false", 0}, {"-contentHashDedup", 3}} {
+		t.Run(tc.flag, func(t *testing.T) {
+			problems := crawlSite(t, pages, tc.flag)
+			// Relative links resolve against every copy.
+			if len(problems) != 1 || problems[0].URL != *root+"b/y" {
+				t.Errorf("want only /b/y reported, got %v", problems)
+			}
+			if got := linkSources[*root+"shared"]; len(got) != 2 {
+				t.Errorf("/shared found on %v, want both copies", got)
+			}
+			if len(pagesByHash) != tc.hashed {
+				t.Errorf("%d pages kept for reuse, want %d", len(pagesByHash), tc.hashed)
+			}
+		})
+	}
+}
+
+func TestStrictContentType(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/odd">odd</a>`})
+	mux.HandleFunc("/odd", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html-ish")
+		fmt.Fprint(w, `<a href="/gone">gone</a>`)
+	})
+	if problems := crawlSite(t, mux); len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/gone") {
+		t.Errorf("want the text/html-ish page parsed by default, got %v", problems)
+	}
+	t.Run("strict", func(t *testing.T) {
+		problems := crawlSite(t, mux, "-strictContentType")
+		if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/odd") || !strings.Contains(problems[0].Message, "not parsed") {
+			t.Errorf("want only the text/html-ish page reported, got %v", problems)
+		}
+	})
+}
+
+func TestAltClassification(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":           `<img src="/chart.png"><img src="/spacer.gif" alt=""><img src="/logo.png" alt="Logo">`,
+		"/chart.png":  ``,
+		"/spacer.gif": ``,
+		"/logo.png":   ``,
+	}, "-checkAlt")
+	severities := make(map[string]string)
+	for _, p := range problems {
+		if p.Kind != kindAlt {
+			t.Errorf("unexpected problem: %v", p)
+		}
+		for _, img := range []string{"/chart.png", "/spacer.gif", "/logo.png"} {
+			if strings.Contains(p.Message, `"`+img+`"`) {
+				severities[img] = p.Severity
+			}
+		}
+	}
+	want := map[string]string{"/chart.png": severityError, "/spacer.gif": severityInfo}
+	if fmt.Sprint(severities) != fmt.Sprint(want) {
+		t.Errorf("got severities %v, want %v", severities, want)
+	}
+}
+
+func TestProbeHTTPS(t *testing.T) {
+	problems := crawlSite(t, site{"/": `<a href="/a">a</a>`, "/a": ``}, "-probeHttps")
+	// The test server doesn't speak TLS, so https fails everywhere.
+	if len(problems) != 2 {
+		t.Fatalf("want both pages reported, got %v", problems)
+	}
+	for _, p := range problems {
+		if p.Kind != kindScheme || !strings.HasPrefix(p.Message, "works over http but not over https") {
+			t.Errorf("unexpected problem: %v", p)
+		}
+	}
+}
+
+func TestLoginRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/account">account</a><a href="/old">old</a>`, "/login": ``, "/new": ``})
+	mux.Handle("/account", http.RedirectHandler("/login?next=/account", http.StatusFound))
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusFound))
+	problems := crawlSite(t, mux, "-loginURLPattern", "/login")
+	if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/account") || !strings.Contains(problems[0].Message, "possible access issue") {
+		t.Errorf("want only /account reported, got %v", problems)
+	}
+}
+
+func TestMaxPathDepth(t *testing.T) {
+	var c hitCounter
+	trap := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<a href="a/">deeper</a>`)
+	})
+	problems := crawlSite(t, c.wrap(trap), "-maxPathDepth", "3")
+	if len(problems) != 1 || problems[0].URL != *root+"a/a/a/a/" || problems[0].Severity != severityInfo {
+		t.Errorf("want only /a/a/a/a/ noted, got %v", problems)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.hits) != 4 || c.hits["/a/a/a/"] != 1 {
+		t.Errorf("want the root and 3 levels below it fetched, got %v", c.hits)
+	}
+}
+
+// slowPage serves the HTML head at once and tail only once released,
+// noting whether the client hung up before.
+type slowPage struct {
+	head, tail string
+	release    chan struct{}
+	hungUp     chan bool
+}
+
+func (p slowPage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, p.head)
+	w.(http.Flusher).Flush()
+	select {
+	case <-r.Context().Done():
+		p.hungUp <- true
+	case <-p.release:
+		fmt.Fprint(w, p.tail)
+		p.hungUp <- false
+	}
+}
+
+func TestParsingStopsEarly(t *testing.T) {
+	for _, tc := range []struct {
+		flag, value, note string
+	}{
+		{"-maxLinksPerPage", "2", "stopped parsing after 2 links"},
+		{"-maxParseTime", "100ms", "stopped parsing after -maxParseTime 100ms"},
+	} {
+		t.Run(tc.flag, func(t *testing.T) {
+			page := slowPage{
+				head:    `<a href="/gone">gone</a><a href="#late">late</a>` + strings.Repeat(" ", 4096),
+				tail:    `<a href="/after">after</a><p id="late">`,
+				release: make(chan struct{}),
+				hungUp:  make(chan bool, 1),
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/", site{})
+			mux.Handle("/{$}", page)
+			done := make(chan []problem)
+			go func() { done <- crawlSite(t, mux, tc.flag, tc.value) }()
+			select {
+			case hungUp := <-page.hungUp:
+				if !hungUp {
+					t.Fatal("body read to the end")
+				}
+			case <-time.After(5 * time.Second):
+				close(page.release)
+				t.Fatal("body not closed early")
+			}
+			problems := <-done
+			// The fragment may come later in the page, so isn't reported.
+			if len(problems) != 2 {
+				t.Fatalf("want 2 problems, got %v", problems)
+			}
+			if _, ok := findProblem(problems, kindLink, "/gone"); !ok {
+				t.Errorf("link before the cut not checked: %v", problems)
+			}
+			if p, ok := findProblem(problems, kindContent, "/"); !ok || p.Message != tc.note {
+				t.Errorf("want note %q, got %v", tc.note, problems)
+			}
+		})
+	}
+}
+
+func TestTitles(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":  `<title>Home</title><a href="/a">a</a><a href="/b">b</a><a href="/c">c</a>`,
+		"/a": `<title>Same</title>`,
+		"/b": `<title>Same</title>`,
+		"/c": `<title> </title>`,
+	}, "-checkTitles")
+	if len(problems) != 3 {
+		t.Fatalf("want 3 problems, got %v", problems)
+	}
+	for page, message := range map[string]string{
+		"/a": fmt.Sprintf("title %q is also used by [%sb]", "Same", *root),
+		"/b": fmt.Sprintf("title %q is also used by [%sa]", "Same", *root),
+		"/c": "missing or empty <title>",
+	} {
+		if p, ok := findProblem(problems, kindTitle, page); !ok || p.Message != message {
+			t.Errorf("want %s reported with %q, got %v", page, message, problems)
+		}
+	}
+}
+
+func TestListRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/a">a</a><a href="/d">d</a>`, "/c": ``})
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusMovedPermanently))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusFound))
+	mux.Handle("/d", http.RedirectHandler("/c", http.StatusFound))
+	problems := crawlSite(t, mux, "-listRedirects")
+	// Only linked URLs are listed, with the end of their chain.
+	want := []string{"/a: 301 redirect to " + *root + "c", "/d: 302 redirect to " + *root + "c"}
+	var got []string
+	for _, p := range problems {
+		if p.Kind == kindRedirect {
+			got = append(got, strings.TrimPrefix(p.URL, strings.TrimSuffix(*root, "/"))+": "+p.Message)
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) || len(problems) != len(want) {
+		t.Errorf("got %v, want %v", problems, want)
+	}
+}
+
+func TestPictureSources(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/": `<picture>
+			<source srcset="/a.webp 1x, /a2.webp 2x" type="image/webp">
+			<source srcset="/a.avif" type="image/avif">
+			<img src="/a.png" alt="a">
+		</picture>`,
+		"/a.webp":  ``,
+		"/a2.webp": ``,
+		"/a.png":   ``,
+	})
+	if len(problems) != 1 || problems[0].Kind != kindImage || !strings.HasSuffix(problems[0].URL, "/a.avif") {
+		t.Errorf("want only /a.avif reported as an image, got %v", problems)
+	}
+}
+
+func TestMaxParses(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<p>")
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		fmt.Fprint(w, "done</p>")
+	})
+	page := ""
+	for i := 0; i < 8; i++ {
+		page += fmt.Sprintf(`<a href="/p/%d">%d</a>`, i, i)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": page})
+	mux.Handle("/p/", slow)
+	if problems := crawlSite(t, mux, "-concurrency", "4", "-maxParses", "2"); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if parsesPeak != 2 {
+		t.Errorf("%d pages parsed at once, want 2", parsesPeak)
+	}
+}
+
+func TestInconsistentTrailingSlash(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":   `<a href="/x">x</a><a href="/y/">y</a>`,
+		"/x":  `<a href="/x/">x again</a>`,
+		"/x/": ``,
+		"/y/": ``,
+	}, "-reportInconsistentTrailingSlash")
+	if len(problems) != 1 || problems[0].URL != *root+"x" || !strings.HasPrefix(problems[0].Message, "also linked with a trailing slash as "+*root+"x/") {
+		t.Errorf("want only /x reported, got %v", problems)
+	}
+}
+
+func TestURLPolicy(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":         `<a href="/about-us">ok</a><a href="/About-Us">upper</a>`,
+		"/about-us": ``,
+		"/About-Us": ``,
+	}, "-urlPolicy", "^[a-z0-9/-]*$")
+	if len(problems) != 1 || problems[0].Kind != kindPolicy || !strings.HasSuffix(problems[0].URL, "/About-Us") {
+		t.Errorf("want only /About-Us reported, got %v", problems)
+	}
+}
+
+func TestMaxExternalChecks(t *testing.T) {
+	var c hitCounter
+	external := httptest.NewServer(c.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+	})))
+	defer external.Close()
+	page := ""
+	for i := 0; i < 5; i++ {
+		page += fmt.Sprintf(`<a href="%s/%d">%d</a>`, external.URL, i, i)
+	}
+	problems := crawlSite(t, site{"/": page + page}, "-maxExternalChecks", "2")
+	want := "external check limit reached, 3 external URLs beyond the first 2 not checked"
+	if len(problems) != 1 || problems[0].Message != want {
+		t.Errorf("want only the limit noted, got %v", problems)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.hits) != 2 || c.hits["/0"] != 1 || c.hits["/1"] != 1 {
+		t.Errorf("want the first two external URLs fetched once, got %v", c.hits)
+	}
+}
+
+func TestRedirectChainToSelf(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/a">a</a>`, "/a": `<a href="/b">b</a><a href="/d">d</a>`, "/e": ``})
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusMovedPermanently))
+	mux.Handle("/c", http.RedirectHandler("/a", http.StatusFound))
+	mux.Handle("/d", http.RedirectHandler("/e", http.StatusFound))
+	problems := crawlSite(t, mux, "-reportRedirectChainToSelf")
+	if len(problems) != 1 || problems[0].URL != *root+"a" || problems[0].Message != "link to "+*root+"b redirects back to this page" {
+		t.Errorf("want only the link from /a to /b reported, got %v", problems)
+	}
+}
+
+func TestSrcdocLinks(t *testing.T) {
+	pages := site{
+		"/":   `<iframe srcdoc="<a href='/gone'>gone</a><a href='/ok'>ok</a>"></iframe>`,
+		"/ok": ``,
+	}
+	if problems := crawlSite(t, pages); len(problems) != 0 {
+		t.Errorf("srcdoc parsed without -parseSrcdoc: %v", problems)
+	}
+	t.Run("parseSrcdoc", func(t *testing.T) {
+		problems := crawlSite(t, pages, "-parseSrcdoc")
+		if len(problems) != 1 || problems[0].URL != *root+"gone" || fmt.Sprint(problems[0].Sources) != "["+*root+"]" {
+			t.Errorf("want only /gone reported, from the page, got %v", problems)
+		}
+	})
+}
+
+func TestRedirectsToSharedTarget(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/old1">1</a><a href="/old2">2</a>`})
+	mux.Handle("/old1", http.RedirectHandler("/gone", http.StatusMovedPermanently))
+	mux.Handle("/old2", http.RedirectHandler("/gone", http.StatusMovedPermanently))
+	problems := crawlSite(t, mux)
+	if len(problems) != 2 {
+		t.Fatalf("want both links reported, got %v", problems)
+	}
+	for _, origin := range []string{"/old1", "/old2"} {
+		if p, ok := findProblem(problems, kindLink, origin); !ok || !strings.HasPrefix(p.Message, "404 Not Found, redirected to ") {
+			t.Errorf("want %s reported with the final 404, got %v", origin, problems)
+		}
+	}
+}
+
+func TestRedirectChainLength(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/h0">chain</a>`, "/h4": ``})
+	for i := 0; i < 4; i++ {
+		mux.Handle(fmt.Sprintf("/h%d", i), http.RedirectHandler(fmt.Sprintf("/h%d", i+1), http.StatusMovedPermanently))
+	}
+	if problems := crawlSite(t, mux, "-maxRedirects", "4"); len(problems) != 0 {
+		t.Errorf("4 redirects allowed, got %v", problems)
+	}
+	t.Run("maxRedirects 3", func(t *testing.T) {
+		problems := crawlSite(t, mux, "-maxRedirects", "3")
+		if len(problems) != 1 || problems[0].URL != *root+"h0" || !strings.HasPrefix(problems[0].Message, "too many redirects (more than 3)") {
+			t.Errorf("want only /h0 reported, got %v", problems)
+		}
+	})
+}
+
+func TestMissingViewport(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":         `<meta name="viewport" content="width=device-width"><a href="/a">a</a><a href="/logo.png">logo</a>`,
+		"/a":        `<p>no viewport</p>`,
+		"/logo.png": ``,
+	}, "-checkViewport")
+	if len(problems) != 1 || problems[0].URL != *root+"a" || !strings.Contains(problems[0].Message, "viewport") {
+		t.Errorf("want only /a reported, got %v", problems)
+	}
+}
+
+func TestInDegrees(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":       `<a href="/a">a</a><a href="/b">b</a><a href="/hub">hub</a>`,
+		"/a":      `<a href="/hub">hub</a><link rel="canonical" href="/orphan">`,
+		"/b":      `<a href="/hub">hub</a><a href="/a">a</a>`,
+		"/hub":    `<a href="/hub">itself</a>`,
+		"/orphan": ``,
+	}, "-minInDegree", "1", "-maxInDegree", "2", "-followCanonical")
+	want := map[string]string{
+		"/orphan": "orphan page, not linked from any other internal page",
+		"/hub":    "linked from 3 other internal pages, more than 2",
+	}
+	var got int
+	for _, p := range problems {
+		if p.Kind == kindGraph {
+			got++
+		}
+	}
+	if got != len(want) {
+		t.Errorf("want %d pages noted, got %v", len(want), problems)
+	}
+	for url, message := range want {
+		if p, ok := findProblem(problems, kindGraph, url); !ok || p.Message != message {
+			t.Errorf("want %s noted as %q, got %v", url, message, problems)
+		}
+	}
+}
+
+func TestFlagDenormalizedURLs(t *testing.T) {
+	srv := httptest.NewServer(nil)
+	defer srv.Close()
+	mixed := strings.Replace(srv.URL, "http://", "HTTP://", 1) + "/a"
+	srv.Config.Handler = site{"/": `<a href="` + mixed + `">mixed</a><a href="/a">clean</a><a href="` + srv.URL + `/a">clean</a>`, "/a": ``}
+	setFlags(t, "-root", srv.URL+"/", "-flagDenormalizedUrls")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	problems := runCrawl()
+	want := fmt.Sprintf("link %q is not normalized, it is %s/a", mixed, srv.URL)
+	if len(problems) != 1 || problems[0].Message != want {
+		t.Errorf("want only the link with an upper case scheme reported, got %v", problems)
+	}
+}
+
+func TestMaxDownload(t *testing.T) {
+	const size = 64 << 20
+	var sent atomic.Int64
+	done := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/big.html">big</a>`})
+	mux.HandleFunc("/big.html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		defer close(done)
+		chunk := []byte(strings.Repeat(`<a href="/gone">`, 1024))
+		for n := 0; n < size; n += len(chunk) {
+			m, err := w.Write(chunk[:min(len(chunk), size-n)])
+			sent.Add(int64(m))
+			if err != nil {
+				return
+			}
+		}
+	})
+	problems := crawlSite(t, mux, "-maxDownload", "65536")
+	if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/big.html") || !strings.HasPrefix(problems[0].Message, "not downloaded") {
+		t.Errorf("want only /big.html noted, got %v", problems)
+	}
+	// Only what fits in the socket buffers gets written before the
+	// connection is closed.
+	<-done
+	if n := sent.Load(); n >= size {
+		t.Errorf("all %d bytes of /big.html sent", n)
+	}
+}
+
+func TestNormalizeUnicodePaths(t *testing.T) {
+	// é as one code point, and as e with a combining accent.
+	pages := site{"/": `<a href="/caf%C3%A9">composed</a><a href="/cafe%CC%81">decomposed</a>`, "/café": ``}
+	for _, tc := range []struct {
+		flag     string
+		problems int
+		fetches  int
+	}{{"-normalizeUnicodePaths=false", 1, 2}, {"-normalizeUnicodePaths", 0, 1}} {
+		t.Run(tc.flag, func(t *testing.T) {
+			var c hitCounter
+			problems := crawlSite(t, c.wrap(pages), tc.flag)
+			if len(problems) != tc.problems {
+				t.Errorf("want %d problems, got %v", tc.problems, problems)
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if got := len(c.hits) - 1; got != tc.fetches {
+				t.Errorf("café fetched as %d URLs, want %d: %v", got, tc.fetches, c.hits)
+			}
+		})
+	}
+}
+
+func TestAssetsOnly(t *testing.T) {
+	for name, pages := range map[string]site{
+		"same page": {"/": `<a href="/logo.png">logo</a><img src="/logo.png" alt="logo"><a href="/gone">gone</a>`},
+		"later page": {
+			"/":  `<a href="/logo.png">logo</a><a href="/gone">gone</a><a href="/a">a</a>`,
+			"/a": `<img src="/logo.png" alt="logo">`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Only linked to, /gone isn't checked.
+			problems := crawlSite(t, pages, "-assetsOnly")
+			if len(problems) != 1 || problems[0].Kind != kindImage || !strings.HasSuffix(problems[0].URL, "/logo.png") {
+				t.Errorf("want only the broken image reported, got %v", problems)
+			}
+		})
+	}
+}
+
+func TestAssetsOnlyContentHashDedup(t *testing.T) {
+	dup := `<script src="/app.js"></script><script src="local.js"></script>`
+	problems := crawlSite(t, site{"/": `<a href="/a/x">a</a><a href="/b/x">b</a>`, "/a/x": dup, "/b/x": dup, "/app.js": ``, "/a/local.js": ``}, "-assetsOnly", "-contentHashDedup")
+	if len(problems) != 1 || problems[0].URL != *root+"b/local.js" || problems[0].Kind != kindAsset {
+		t.Errorf("want only /b/local.js reported, got %v", problems)
+	}
+	if got := linkSources[*root+"app.js"]; len(got) != 2 {
+		t.Errorf("/app.js found on %v, want both copies", got)
+	}
+}
+
+func TestMaxRequests(t *testing.T) {
+	pages := site{
+		"/":                `<link rel="manifest" href="/app.webmanifest"><a href="/a">a</a><a href="/b">b</a><a href="/c">c</a>`,
+		"/app.webmanifest": `{}`,
+		"/a":               ``,
+		"/b":               ``,
+		"/c":               ``,
+	}
+	// The manifest is fetched right after the root, the links later.
+	for _, tc := range []struct{ max, refused int }{{1, 4}, {3, 2}, {5, 0}} {
+		t.Run(fmt.Sprint(tc.max), func(t *testing.T) {
+			var c hitCounter
+			problems := crawlSite(t, c.wrap(pages), "-maxRequests", fmt.Sprint(tc.max), "-checkManifest")
+			c.mu.Lock()
+			hits := 0
+			for _, n := range c.hits {
+				hits += n
+			}
+			c.mu.Unlock()
+			if hits != min(tc.max, len(pages)) {
+				t.Errorf("%d requests made, want %d", hits, min(tc.max, len(pages)))
+			}
+			var want []problem
+			if tc.refused > 0 {
+				want = []problem{{Kind: kindLink, Severity: severityInfo, URL: *root,
+					Message: fmt.Sprintf("request ceiling reached after %d requests, %d more not made", tc.max, tc.refused)}}
+			}
+			if fmt.Sprint(problems) != fmt.Sprint(want) {
+				t.Errorf("got %v, want only %v", problems, want)
+			}
+		})
+	}
+}
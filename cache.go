@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// checkCacheHeaders warns about caching headers of the internal resource
+// url that look misconfigured: a Vary without Cache-Control, leaving
+// caches to guess, and static assets of a -cacheStaticTypes type without
+// Cache-Control.
+func checkCacheHeaders(url string, header http.Header) {
+	if header.Get("Cache-Control") != "" {
+		return
+	}
+	if vary := header.Values("Vary"); len(vary) > 0 {
+		addPageWarning(kindCache, url, fmt.Sprintf("Vary: %s without Cache-Control", strings.Join(vary, ", ")))
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return
+	}
+	for _, prefix := range strings.Split(*cacheTypes, ",") {
+		if prefix = strings.TrimSpace(prefix); prefix != "" && strings.HasPrefix(mediaType, prefix) {
+			addPageWarning(kindCache, url, fmt.Sprintf("static %s asset without Cache-Control", mediaType))
+			return
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCacheHeaders(t *testing.T) {
+	files := site{"/": `<img src="/a.png" alt="a"><img src="/b.png" alt="b"><a href="/page">page</a><a href="/vary">vary</a>`, "/a.png": ``, "/b.png": ``, "/page": ``, "/vary": ``}
+	mux := http.NewServeMux()
+	mux.Handle("/", files)
+	mux.HandleFunc("/b.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=86400")
+		files.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/vary", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept-Encoding")
+		files.ServeHTTP(w, r)
+	})
+	problems := crawlSite(t, mux, "-checkCacheHeaders")
+	want := map[string]string{
+		"/a.png": "static image/png asset without Cache-Control",
+		"/vary":  "Vary: Accept-Encoding without Cache-Control",
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("want %d problems, got %v", len(want), problems)
+	}
+	for url, message := range want {
+		if p, ok := findProblem(problems, kindCache, url); !ok || p.Message != message {
+			t.Errorf("want %s reported with %q, got %v", url, message, problems)
+		}
+	}
+}
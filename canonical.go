@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// checkCanonicalChains follows the canonical URL declared by every crawled
+// page and warns about chains that loop back on themselves or run longer
+// than -maxCanonicalDepth. Each loop is reported once.
+func checkCanonicalChains() {
+	pages := make([]string, 0, len(canonicalOf))
+	for page := range canonicalOf {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	reported := make(map[string]bool) // sorted loop members -> already reported
+	for _, page := range pages {
+		chain := []string{page}
+		seen := map[string]bool{page: true}
+		for url := page; ; {
+			next, ok := canonicalOf[url]
+			if !ok || next == url {
+				break
+			}
+			chain = append(chain, next)
+			if seen[next] {
+				loop := loopMembers(chain)
+				if !reported[loop] {
+					reported[loop] = true
+					addPageWarning(kindCanonical, page, fmt.Sprintf("canonical loop %s", strings.Join(chain, " -> ")))
+				}
+				break
+			}
+			if len(chain) > *maxCanonDepth {
+				addPageWarning(kindCanonical, page, fmt.Sprintf("canonical chain longer than %d", *maxCanonDepth))
+				break
+			}
+			seen[next] = true
+			url = next
+		}
+	}
+}
+
+// loopMembers identifies the loop closed by the last element of chain by
+// its sorted members.
+func loopMembers(chain []string) string {
+	last := chain[len(chain)-1]
+	var members []string
+	for i := len(chain) - 2; i >= 0 && chain[i] != last; i-- {
+		members = append(members, chain[i])
+	}
+	members = append(members, last)
+	sort.Strings(members)
+	return strings.Join(members, " ")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalLoop(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":  `<a href="/a">a</a>`,
+		"/a": `<link rel="canonical" href="/b"><a href="/b">b</a>`,
+		"/b": `<link rel="canonical" href="/a">`,
+	}, "-followCanonical")
+	var loops []problem
+	for _, p := range problems {
+		if p.Kind == kindCanonical && strings.HasPrefix(p.Message, "canonical loop") {
+			loops = append(loops, p)
+		}
+	}
+	if len(loops) != 1 {
+		t.Fatalf("got %d canonical loops reported, want 1: %v", len(loops), problems)
+	}
+	if msg := loops[0].Message; strings.Count(msg, " -> ") != 2 || !strings.Contains(msg, "/b -> ") {
+		t.Errorf("loop message %q doesn't list the chain", loops[0].Message)
+	}
+}
+
+func TestResolveAgainstCanonical(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/": `<link rel="icon" href="/favicon.ico"><a href="/print/page">page</a>`,
+		"/print/page": `<link rel="canonical" href="/docs/page">
+			<link rel="icon" href="icon.png"><link rel="manifest" href="app.webmanifest"><link rel="amphtml" href="page.amp">
+			<a href="other">other</a><img src="figure.png" alt="figure">`,
+		"/favicon.ico":          ``,
+		"/docs/page":            ``,
+		"/docs/icon.png":        ``,
+		"/docs/app.webmanifest": `{"start_url": "page"}`,
+		"/docs/page.amp":        ``,
+		"/docs/other":           ``,
+		"/docs/figure.png":      ``,
+	}, "-resolveRelativeAgainstCanonical", "-checkFavicon", "-checkManifest", "-checkAmp")
+	if len(problems) != 0 {
+		t.Errorf("want every reference resolved against the canonical URL, got %v", problems)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"time"
+)
+
+// dumpConfig writes the value of every flag, set or defaulted, to w as a
+// JSON object keyed by flag name. Durations are written like "90s".
+func dumpConfig(w io.Writer) error {
+	config := make(map[string]interface{})
+	flag.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if _, isDuration := getter.Get().(time.Duration); !isDuration {
+				config[f.Name] = getter.Get()
+			}
+		}
+	})
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(config)
+}
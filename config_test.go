@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDumpConfig(t *testing.T) {
+	setFlags(t, "-concurrency", "4", "-idleConnTimeout", "5s", "-format", "json", "-checkTitles")
+	var out bytes.Buffer
+	if err := dumpConfig(&out); err != nil {
+		t.Fatal(err)
+	}
+	var config map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &config); err != nil {
+		t.Fatalf("not JSON: %v\n%s", err, out.String())
+	}
+	for name, want := range map[string]interface{}{
+		"concurrency":     4.0,
+		"idleConnTimeout": "5s",
+		"format":          "json",
+		"checkTitles":     true,
+		"checkAlt":        false,
+		"maxRedirects":    10.0,
+	} {
+		if got := config[name]; got != want {
+			t.Errorf("%s dumped as %#v, want %#v", name, got, want)
+		}
+	}
+}
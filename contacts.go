@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	neturl "net/url"
+	"regexp"
+	"strings"
+)
+
+// telRe matches a tel: number with visual separators removed: E.164-ish,
+// optionally international, 3 to 15 digits.
+var telRe = regexp.MustCompile(`^\+?[0-9]{3,15}$`)
+
+// checkContact reports a malformed mailto: or tel: link ref found on the
+// page at sourceURL, without making any request for it.
+func checkContact(ref, sourceURL string) {
+	var err error
+	switch {
+	case strings.HasPrefix(ref, "mailto:"):
+		err = validateMailto(strings.TrimPrefix(ref, "mailto:"))
+	case strings.HasPrefix(ref, "tel:"):
+		err = validateTel(strings.TrimPrefix(ref, "tel:"))
+	default:
+		return
+	}
+	if err != nil {
+		addPageProblem(severityError, kindContact, sourceURL, fmt.Sprintf("malformed link %q: %v", ref, err))
+	}
+}
+
+// validateMailto checks the comma separated addresses of a mailto: URL,
+// which may instead be given in a to= header field.
+func validateMailto(s string) error {
+	addrs, query, _ := strings.Cut(s, "?")
+	addrs, err := neturl.PathUnescape(addrs)
+	if err != nil {
+		return err
+	}
+	if addrs == "" {
+		if values, err := neturl.ParseQuery(query); err == nil && values.Get("to") != "" {
+			addrs = values.Get("to")
+		} else {
+			return errors.New("no address")
+		}
+	}
+	for _, addr := range strings.Split(addrs, ",") {
+		if _, err := mail.ParseAddress(strings.TrimSpace(addr)); err != nil {
+			return fmt.Errorf("%q is not an email address", addr)
+		}
+	}
+	return nil
+}
+
+// validateTel checks the number of a tel: URL, ignoring visual separators
+// and parameters like ;ext=.
+func validateTel(s string) error {
+	number, _, _ := strings.Cut(s, ";")
+	number, err := neturl.PathUnescape(number)
+	if err != nil {
+		return err
+	}
+	digits := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(number)
+	if !telRe.MatchString(digits) {
+		return fmt.Errorf("%q is not a phone number", number)
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateContacts(t *testing.T) {
+	problems := crawlSite(t, site{"/": `
+		<a href="mailto:info@example.com">ok</a>
+		<a href="mailto:?to=a@example.com,b@example.com&amp;subject=Hi">ok</a>
+		<a href="tel:+1-555-010-0199;ext=12">ok</a>
+		<a href="mailto:info.example.com">bad</a>
+		<a href="tel:call-us">bad</a>`,
+	}, "-validateContacts")
+	var bad []string
+	for _, p := range problems {
+		if p.Kind != kindContact {
+			t.Errorf("unexpected problem: %v", p)
+			continue
+		}
+		bad = append(bad, p.Message)
+	}
+	if len(bad) != 2 || !strings.Contains(bad[0], `"mailto:info.example.com"`) || !strings.Contains(bad[1], `"tel:call-us"`) {
+		t.Errorf("want only the malformed mailto: and tel: reported, got %v", problems)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// jar holds the cookies sent with requests. It is nil unless -cookieFile
+// is given.
+var jar *cookiejar.Jar
+
+// loadCookieFile reads cookies in the Netscape cookies.txt format used by
+// curl and browser exports into jar.
+func loadCookieFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	jar, err = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "") // empty value
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("%s:%d: want 7 tab separated fields, got %d", path, lineno, len(fields))
+		}
+		domain, includeSubdomains, cookiePath, secure, expires, name, value := fields[0], fields[1], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+		c := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     cookiePath,
+			Secure:   strings.EqualFold(secure, "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		host := strings.TrimPrefix(domain, ".")
+		if strings.EqualFold(includeSubdomains, "TRUE") {
+			c.Domain = host
+		}
+		if sec, err := strconv.ParseInt(expires, 10, 64); err != nil {
+			return fmt.Errorf("%s:%d: bad expiry %q", path, lineno, expires)
+		} else if sec > 0 {
+			c.Expires = time.Unix(sec, 0)
+		}
+
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&neturl.URL{Scheme: scheme, Host: host, Path: cookiePath}, []*http.Cookie{c})
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCookieFile(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string]string) // host -> session cookie received
+	record := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			if c, err := r.Cookie("session"); err == nil {
+				sent[strings.Split(r.Host, ":")[0]] = c.Value
+			}
+			mu.Unlock()
+			h.ServeHTTP(w, r)
+		})
+	}
+	other := httptest.NewServer(record(site{"/": ``}))
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	cookies := "# Netscape HTTP Cookie File\n127.0.0.1\tFALSE\t/\tFALSE\t0\tsession\tabc\n"
+	if err := os.WriteFile(path, []byte(cookies), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	problems := crawlSite(t, record(site{
+		"/": `<a href="` + otherURL + `/">other</a>`,
+	}), "-cookieFile", path)
+	if len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if got := sent["127.0.0.1"]; got != "abc" {
+		t.Errorf("cookie sent to its host = %q, want abc", got)
+	}
+	if got, ok := sent["localhost"]; ok {
+		t.Errorf("cookie %q sent to another host", got)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	dnsMu    sync.Mutex
+	dnsTimes = map[string]time.Duration{} // host -> slowest resolution
+)
+
+// resolver looks up host names for resolvingDialContext.
+var resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+} = net.DefaultResolver
+
+// resolvingDialContext returns a DialContext resolving host names itself
+// first, so that resolution can be timed and limited to -dnsTimeout. The
+// dialer then looks the name up again, so that it can race the IPv4 and
+// IPv6 addresses as usual.
+func resolvingDialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, err := net.SplitHostPort(addr); err == nil && net.ParseIP(host) == nil {
+			if _, err := resolve(ctx, host); err != nil {
+				return nil, err
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// resolve looks up the addresses of host, recording how long it took.
+func resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if *dnsTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *dnsTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	ips, err := resolver.LookupIPAddr(ctx, host)
+	took := time.Since(start)
+
+	dnsMu.Lock()
+	if took > dnsTimes[host] {
+		dnsTimes[host] = took
+	}
+	dnsMu.Unlock()
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTimeout && *dnsTimeout > 0 && took >= *dnsTimeout {
+		return nil, fmt.Errorf("DNS lookup of %s took longer than %v", host, *dnsTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+	return ips, nil
+}
+
+// reportSlowDNS warns about every host whose name took longer than
+// threshold to resolve.
+func reportSlowDNS(threshold time.Duration) {
+	dnsMu.Lock()
+	defer dnsMu.Unlock()
+	hosts := make([]string, 0, len(dnsTimes))
+	for host, took := range dnsTimes {
+		if took > threshold {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		addPageWarning(kindDNS, host, fmt.Sprintf("DNS resolution took %v", dnsTimes[host].Round(time.Millisecond)))
+	}
+}
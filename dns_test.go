@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubResolver resolves every name to 127.0.0.1 after the delay given for
+// it, failing like net.Resolver if ctx ends first. Only the delay matters,
+// as the dialer resolves the name again.
+type stubResolver map[string]time.Duration
+
+func (r stubResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	select {
+	case <-time.After(r[host]):
+		return []net.IPAddr{{IP: net.IPv4(127, 0, 0, 1)}}, nil
+	case <-ctx.Done():
+		return nil, &net.DNSError{Err: ctx.Err().Error(), Name: host, IsTimeout: true}
+	}
+}
+
+func TestSlowDNS(t *testing.T) {
+	saved := resolver
+	resolver = stubResolver{"localhost": 150 * time.Millisecond, "stuck.test": time.Minute}
+	t.Cleanup(func() { resolver = saved })
+	srv := httptest.NewServer(site{"/": `<a href="/a">a</a>`, "/a": ``})
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	setFlags(t, "-root", "http://localhost:"+port+"/",
+		"-reportSlowDns", "-slowDnsThreshold", "100ms", "-dnsTimeout", "300ms")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	problems := runCrawl()
+	if len(problems) != 1 || problems[0].Kind != kindDNS || problems[0].URL != "localhost" {
+		t.Errorf("want localhost reported as slow, got %v", problems)
+	}
+
+	// The page linking to a host that never resolves is reported.
+	resetCrawl()
+	srv.Config.Handler = site{"/": `<a href="http://stuck.test:` + port + `/">stuck</a>`}
+	problems = runCrawl()
+	p, ok := findProblem(problems, kindLink, "stuck.test:"+port+"/")
+	if !ok || !strings.Contains(p.Message, "took longer than 300ms") {
+		t.Errorf("want the stuck host reported after -dnsTimeout, got %v", problems)
+	}
+}
+
+func TestNoResolvingWithoutDNSFlags(t *testing.T) {
+	saved := resolver
+	resolver = stubResolver{"localhost": time.Minute}
+	t.Cleanup(func() { resolver = saved })
+	srv := httptest.NewServer(site{"/": ``})
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	setFlags(t, "-root", "http://localhost:"+port+"/")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	if problems := runCrawl(); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+	if len(dnsTimes) != 0 {
+		t.Errorf("host names resolved for timing: %v", dnsTimes)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// summarizeExternalDomains notes, for every registered domain linked to,
+// how many distinct external URLs on it are linked, how many of those are
+// broken, and how many weren't checked because of -maxExternalChecks.
+func summarizeExternalDomains() {
+	broken := make(map[string]bool)
+	for _, p := range problems {
+		if isError(p) && p.linked {
+			broken[p.URL] = true
+		}
+	}
+	type counts struct{ total, broken, unchecked int }
+	domains := make(map[string]*counts)
+	for url := range linkSources {
+		if isInternal(url) {
+			continue
+		}
+		domain := registeredDomain(url)
+		if domain == "" {
+			continue
+		}
+		c := domains[domain]
+		if c == nil {
+			c = &counts{}
+			domains[domain] = c
+		}
+		c.total++
+		switch {
+		case broken[url]:
+			c.broken++
+		case externalSkipped[url]:
+			c.unchecked++
+		}
+	}
+
+	names := make([]string, 0, len(domains))
+	for domain := range domains {
+		names = append(names, domain)
+	}
+	sort.Strings(names)
+	for _, domain := range names {
+		c := domains[domain]
+		msg := fmt.Sprintf("%d external URLs linked, %d broken", c.total, c.broken)
+		if c.unchecked > 0 {
+			msg += fmt.Sprintf(", %d not checked", c.unchecked)
+		}
+		addPageProblem(severityInfo, kindDomain, domain, msg)
+	}
+}
+
+// registeredDomain returns the domain under a public suffix that url is
+// on, like example.co.uk for https://www.example.co.uk/. It falls back to
+// the host name, e.g. for IP addresses.
+func registeredDomain(url string) string {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(u.Hostname())
+	if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return domain
+	}
+	return host
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExternalDomainSummary(t *testing.T) {
+	external := httptest.NewServer(site{"/ok": ``})
+	defer external.Close()
+	// Under another host name, the same server is another domain.
+	byName := strings.Replace(external.URL, "127.0.0.1", "localhost", 1)
+	problems := crawlSite(t, site{
+		"/": `<a href="` + external.URL + `/ok">1</a><a href="` + external.URL + `/gone">2</a>` +
+			`<a href="` + byName + `/ok">3</a><a href="` + byName + `/late">4</a>`,
+	}, "-externalDomainSummary", "-maxExternalChecks", "3")
+	for domain, want := range map[string]string{
+		"127.0.0.1": "2 external URLs linked, 1 broken",
+		"localhost": "2 external URLs linked, 0 broken, 1 not checked",
+	} {
+		if p, ok := findProblem(problems, kindDomain, domain); !ok || p.Message != want {
+			t.Errorf("want %s summarized as %q, got %v", domain, want, problems)
+		}
+	}
+}
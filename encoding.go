@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+// checkSourceEncoding warns when the charset declared for the internal page
+// at url disagrees with itself or with body: the Content-Type and <meta>
+// declaring different charsets, a byte order mark for another encoding, or
+// a page declared as UTF-8 that isn't valid UTF-8.
+func checkSourceEncoding(url, contentType, metaCharset string, body []byte) {
+	var header string
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		header = params["charset"]
+	}
+	if header != "" && metaCharset != "" && canonicalCharset(header) != canonicalCharset(metaCharset) {
+		addPageWarning(kindEncoding, url, fmt.Sprintf("Content-Type declares charset %s but <meta> declares %s", header, metaCharset))
+	}
+	declared := canonicalCharset(header)
+	if declared == "" {
+		declared = canonicalCharset(metaCharset)
+	}
+	if declared == "" {
+		return
+	}
+	bom := bomCharset(body)
+	if bom != "" && bom != declared {
+		addPageWarning(kindEncoding, url, fmt.Sprintf("byte order mark is for %s but charset %s is declared", bom, declared))
+		return
+	}
+	if declared == "utf-8" && !utf8.Valid(body) {
+		addPageWarning(kindEncoding, url, "declared as UTF-8 but isn't valid UTF-8")
+	}
+}
+
+// canonicalCharset returns the standard name of the charset label, so
+// aliases like latin1 and iso-8859-1 compare equal.
+func canonicalCharset(label string) string {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return ""
+	}
+	if _, name := charset.Lookup(label); name != "" {
+		return name
+	}
+	return strings.ToLower(label)
+}
+
+// bomCharset returns the encoding named by a byte order mark starting body,
+// if any.
+func bomCharset(body []byte) string {
+	switch {
+	case bytes.HasPrefix(body, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8"
+	case bytes.HasPrefix(body, []byte{0xfe, 0xff}):
+		return "utf-16be"
+	case bytes.HasPrefix(body, []byte{0xff, 0xfe}):
+		return "utf-16le"
+	}
+	return ""
+}
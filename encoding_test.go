@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func TestInvalidUTF8(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":      `<a href="/latin">latin</a><a href="/utf8">utf8</a>`,
+		"/latin": "<p>caf\xe9</p>",
+		"/utf8":  "<p>café</p>",
+	}, "-reportSourceEncoding")
+	if len(problems) != 1 || problems[0].Kind != kindEncoding || problems[0].URL != *root+"latin" || problems[0].Message != "declared as UTF-8 but isn't valid UTF-8" {
+		t.Errorf("want only /latin reported, got %v", problems)
+	}
+}
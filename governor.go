@@ -0,0 +1,68 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// Bounds of the delay the -throttleOnErrorRate governor adds per request.
+const (
+	minThrottleDelay = 250 * time.Millisecond
+	maxThrottleDelay = 10 * time.Second
+)
+
+// throttleWindow is the number of recent responses the error rate is
+// taken over.
+const throttleWindow = 20
+
+// Guarded by stateMu:
+var (
+	recentFailures []bool        // outcome of the last requests, oldest first
+	throttleDelay  time.Duration // current delay before each request
+	sinceSlowdown  int           // requests since throttleDelay was last raised
+)
+
+// throttle sleeps before a request while the -throttleOnErrorRate governor
+// is slowing the crawl down.
+func throttle() {
+	if delay := throttleDelay; delay > 0 {
+		unlocked(func() { time.Sleep(delay) })
+	}
+}
+
+// recordOutcome adds the outcome of a request to the rolling error rate,
+// doubling the delay between requests every few requests while the rate is
+// above -throttleOnErrorRate, and halving it again once it is not.
+func recordOutcome(failed bool) {
+	recentFailures = append(recentFailures, failed)
+	if len(recentFailures) > throttleWindow {
+		recentFailures = recentFailures[1:]
+	}
+	failures := 0
+	for _, f := range recentFailures {
+		if f {
+			failures++
+		}
+	}
+	rate := float64(failures) / float64(len(recentFailures))
+
+	prev := throttleDelay
+	sinceSlowdown++
+	switch {
+	case len(recentFailures) < throttleWindow/2:
+		// Too few requests for a meaningful rate.
+	case rate > *throttleRate:
+		// Give the site a few requests to recover before slowing down more.
+		if throttleDelay == 0 || sinceSlowdown >= throttleWindow/4 {
+			throttleDelay = min(max(2*throttleDelay, minThrottleDelay), maxThrottleDelay)
+			sinceSlowdown = 0
+		}
+	case throttleDelay > 0:
+		if throttleDelay /= 2; throttleDelay < minThrottleDelay {
+			throttleDelay = 0
+		}
+	}
+	if *verbose && throttleDelay != prev {
+		log.Printf("Error rate %.0f%%, delaying requests by %v", 100*rate, throttleDelay)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGovernorBacksOff(t *testing.T) {
+	setFlags(t, "-throttleOnErrorRate", "0.5")
+	var delays []time.Duration
+	record := func(n int, failed bool) {
+		for i := 0; i < n; i++ {
+			recordOutcome(failed)
+			delays = append(delays, throttleDelay)
+		}
+	}
+	// Too few requests for a rate at first, then a slowdown every few
+	// requests while most fail.
+	record(9, true)
+	if throttleDelay != 0 {
+		t.Fatalf("throttled after 9 requests: %v", delays)
+	}
+	record(1, true)
+	if throttleDelay != minThrottleDelay {
+		t.Fatalf("delay %v after 10 failures, want %v", throttleDelay, minThrottleDelay)
+	}
+	record(4, true)
+	if throttleDelay != minThrottleDelay {
+		t.Fatalf("delay raised again too soon: %v", delays)
+	}
+	record(1, true)
+	if throttleDelay != 2*minThrottleDelay {
+		t.Fatalf("delay %v after 15 failures, want %v", throttleDelay, 2*minThrottleDelay)
+	}
+	for i := 0; i < 20; i++ {
+		record(5, true)
+	}
+	if throttleDelay != maxThrottleDelay {
+		t.Fatalf("delay %v after many failures, want the maximum %v", throttleDelay, maxThrottleDelay)
+	}
+	// Recovering, it halves with every good request while the rate of the
+	// last throttleWindow ones is at most 0.5, then stops.
+	record(throttleWindow/2, false)
+	if throttleDelay != maxThrottleDelay/2 {
+		t.Fatalf("delay %v once half of the window succeeded, want %v", throttleDelay, maxThrottleDelay/2)
+	}
+	record(throttleWindow/2, false)
+	if throttleDelay != 0 {
+		t.Errorf("still throttled after a window of successes: %v", delays[len(delays)-throttleWindow:])
+	}
+}
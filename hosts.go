@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	neturl "net/url"
+	"os"
+	"strings"
+)
+
+// internalHosts are the hosts, besides the root, whose URLs are part of the
+// crawled site. Keys are lower case host names, including any port.
+var internalHosts = map[string]bool{}
+
+// loadHostAliases reads a -hostAliases file. Each line lists host names,
+// separated by spaces or commas, that together make up one logical site;
+// # starts a comment. The group containing the root's host becomes
+// internal.
+func loadHostAliases(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rootURL, err := neturl.Parse(*root)
+	if err != nil {
+		return err
+	}
+	rootHost := strings.ToLower(rootURL.Host)
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		hosts := strings.FieldsFunc(strings.ToLower(line), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		inGroup := false
+		for _, host := range hosts {
+			if host == rootHost {
+				inGroup = true
+			}
+		}
+		if !inGroup {
+			continue
+		}
+		found = true
+		for _, host := range hosts {
+			internalHosts[host] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !found {
+		log.Printf("No host alias group in %s contains %s", path, rootHost)
+	}
+	return nil
+}
+
+// isInternal reports whether url is part of the crawled site: below the
+// root, or on one of its aliased hosts.
+func isInternal(url string) bool {
+	if strings.HasPrefix(url, *root) {
+		return true
+	}
+	if len(internalHosts) == 0 {
+		return false
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return false
+	}
+	return internalHosts[strings.ToLower(u.Host)]
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHostAliases(t *testing.T) {
+	cdn := httptest.NewServer(site{"/": `<a href="/gone">gone</a>`})
+	defer cdn.Close()
+	static := httptest.NewServer(site{"/": `<a href="` + cdn.URL + `/">cdn</a>`})
+	defer static.Close()
+	other := httptest.NewServer(site{"/": `<a href="/gone">gone</a>`})
+	defer other.Close()
+	host := func(url string) string { return strings.TrimPrefix(url, "http://") }
+
+	path := filepath.Join(t.TempDir(), "aliases")
+	aliases := "# one site\nROOT, " + host(static.URL) + " " + host(cdn.URL) + "\n" + host(other.URL) + "\n"
+	srv := httptest.NewServer(site{"/": `<a href="` + static.URL + `/">static</a><a href="` + other.URL + `/">other</a>`})
+	defer srv.Close()
+	if err := os.WriteFile(path, []byte(strings.Replace(aliases, "ROOT", host(srv.URL), 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setFlags(t, "-root", srv.URL+"/", "-hostAliases", path)
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{srv.URL + "/x", static.URL + "/x", cdn.URL + "/x"} {
+		if !isInternal(url) {
+			t.Errorf("%s is not internal", url)
+		}
+	}
+	if isInternal(other.URL + "/x") {
+		t.Errorf("%s, in another group, is internal", other.URL)
+	}
+	problems := runCrawl()
+	if len(problems) != 1 || problems[0].URL != cdn.URL+"/gone" {
+		t.Errorf("want only the broken link on the aliased cdn host reported, got %v", problems)
+	}
+}
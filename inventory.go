@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	neturl "net/url"
+	"os"
+	"strings"
+
+	"github.com/PuerkitoBio/purell"
+)
+
+// inventory holds the valid internal URLs given by -urlInventory, both full
+// URLs and bare paths. It is nil unless the flag is set.
+var inventory map[string]bool
+
+// loadInventory reads a -urlInventory file: one URL or path per line, with
+// # starting a comment line.
+func loadInventory(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	inventory = make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			inventory[line] = true
+		} else if u, err := purell.NormalizeURLString(line, purell.FlagsSafe); err == nil {
+			inventory[u] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// inInventory reports whether the internal URL url, without fragment, is
+// listed in the inventory by full URL or by path.
+func inInventory(url string) bool {
+	if inventory[url] {
+		return true
+	}
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return false
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" && inventory[path+"?"+u.RawQuery] {
+		return true
+	}
+	return inventory[path]
+}
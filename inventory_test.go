@@ -0,0 +1,26 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestURLInventory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory")
+	if err := os.WriteFile(path, []byte("# routes\n/\n/a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var c hitCounter
+	problems := crawlSite(t, c.wrap(site{
+		"/":  `<a href="/a">a</a><a href="/b">b</a>`,
+		"/a": ``,
+		"/b": ``,
+	}), "-urlInventory", path)
+	if len(problems) != 1 || problems[0].URL != *root+"b" || problems[0].Message != "not in the URL inventory" {
+		t.Errorf("want only /b reported, got %v", problems)
+	}
+	if c.count("/b") != 0 {
+		t.Error("/b was fetched")
+	}
+}
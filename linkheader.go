@@ -0,0 +1,72 @@
+package main
+
+import "strings"
+
+// A headerLink is one link of a Link response header.
+type headerLink struct {
+	url  string
+	rels []string // lower case
+}
+
+// parseLinkHeader parses Link header values as defined by RFC 8288, e.g.
+// `<http://example.com/?page=2>; rel="next", </>; rel=start`. Parameters
+// other than rel are ignored, as are malformed links.
+func parseLinkHeader(values []string) []headerLink {
+	var links []headerLink
+	for _, v := range values {
+		for {
+			start := strings.IndexByte(v, '<')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(v[start:], '>')
+			if end < 0 {
+				break
+			}
+			l := headerLink{url: strings.TrimSpace(v[start+1 : start+end])}
+			params, rest := splitLinkParams(v[start+end+1:])
+			for _, param := range params {
+				name, value, _ := strings.Cut(param, "=")
+				if strings.EqualFold(strings.TrimSpace(name), "rel") {
+					value = strings.Trim(strings.TrimSpace(value), `"`)
+					l.rels = strings.Fields(strings.ToLower(value))
+				}
+			}
+			links = append(links, l)
+			v = rest
+		}
+	}
+	return links
+}
+
+// splitLinkParams splits the ;-separated parameters following a link's
+// <URL> up to the comma ending the link, honoring quoted strings. It
+// returns the parameters and what follows the comma.
+func splitLinkParams(s string) (params []string, rest string) {
+	quoted := false
+	begin := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == ';' && !quoted:
+			params = append(params, s[begin:i])
+			begin = i + 1
+		case c == ',' && !quoted:
+			return append(params, s[begin:i]), s[i+1:]
+		}
+	}
+	return append(params, s[begin:]), ""
+}
+
+// hasRel reports whether l has the relation type rel.
+func (l headerLink) hasRel(rel string) bool {
+	for _, r := range l.rels {
+		if r == rel {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestFollowLinkHeader(t *testing.T) {
+	pages := site{
+		"/page2": `<a href="/from-next">x</a>`,
+		"/doc":   `<a href="/from-doc">x</a>`,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", pages)
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `</page2>; rel="next", </gone>; rel=next`)
+		w.Header().Add("Link", `</doc>; rel="alternate"; type="text/html", <https://fonts.example>; rel=preconnect`)
+		w.Header().Set("Content-Type", "text/html")
+	})
+	problems := crawlSite(t, mux, "-followLinkHeader")
+	// The next pages are crawled, the alternate only checked to exist.
+	if len(problems) != 2 {
+		t.Fatalf("want 2 problems, got %v", problems)
+	}
+	if p, ok := findProblem(problems, kindLinkHeader, "/gone"); !ok || p.Message != "404 Not Found" {
+		t.Errorf("broken rel=next link not reported: %v", problems)
+	}
+	if _, ok := findProblem(problems, kindLink, "/from-next"); !ok {
+		t.Errorf("next page not crawled: %v", problems)
+	}
+}
+
+func TestLinkHeaderTargetLinkedLater(t *testing.T) {
+	pages := site{
+		"/a":   `<a href="/alt">alt</a>`,
+		"/alt": `<a href="/gone">gone</a>`,
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", pages)
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", `</alt>; rel="alternate"; hreflang="de"`)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/a">a</a>`))
+	})
+	// Linked by /a after the header, /alt is a page to parse after all.
+	problems := crawlSite(t, mux, "-followLinkHeader")
+	if len(problems) != 1 || problems[0].URL != *root+"gone" {
+		t.Errorf("want only /gone reported, got %v", problems)
+	}
+}
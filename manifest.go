@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// webManifest is the part of a web app manifest with URLs in it.
+type webManifest struct {
+	StartURL string `json:"start_url"`
+	Icons    []struct {
+		Src string `json:"src"`
+	} `json:"icons"`
+}
+
+// checkManifest fetches the web app manifest at manifestURL, linked from
+// pageURL, following redirects, and queues its start_url and icons, which
+// are relative to where the manifest was found, for crawling.
+func checkManifest(manifestURL, pageURL string) {
+	if manifestChecked[manifestURL] {
+		return
+	}
+	manifestChecked[manifestURL] = true
+	addSource(manifestURL, pageURL)
+	targetKind[manifestURL] = kindManifest
+
+	res, err := fetchFinal(manifestURL)
+	if errors.Is(err, errRequestCeiling) {
+		return
+	}
+	if err != nil {
+		addProblem(manifestURL, err.Error())
+		return
+	}
+	defer closeBody(res)
+	if res.StatusCode != 200 {
+		addProblem(manifestURL, res.Status)
+		return
+	}
+	var body []byte
+	if unlocked(func() { body, err = io.ReadAll(res.Body) }); err != nil {
+		addProblem(manifestURL, fmt.Sprintf("reading manifest: %v", err))
+		return
+	}
+	var m webManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		addProblem(manifestURL, fmt.Sprintf("parsing manifest: %v", err))
+		return
+	}
+	base := res.Request.URL.String()
+	if m.StartURL != "" {
+		checkRef(m.StartURL, base, kindLink)
+	}
+	for _, icon := range m.Icons {
+		if icon.Src != "" {
+			checkRef(icon.Src, base, kindImage)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestManifestMissingIcon(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<link rel="manifest" href="/app.webmanifest">`, "/app/": ``, "/icons/192.png": ``})
+	mux.Handle("/app.webmanifest", http.RedirectHandler("/static/manifest.json", http.StatusMovedPermanently))
+	mux.HandleFunc("/static/manifest.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/manifest+json")
+		w.Write([]byte(`{"start_url": "/app/", "icons": [{"src": "/icons/192.png"}, {"src": "icons/512.png"}]}`))
+	})
+	problems := crawlSite(t, mux, "-checkManifest")
+	if len(problems) != 1 || problems[0].URL != *root+"static/icons/512.png" || problems[0].Kind != kindImage {
+		t.Errorf("want only the missing icon reported, got %v", problems)
+	}
+}
+
+func TestSlowManifest(t *testing.T) {
+	headers, third := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<link rel="manifest" href="/app.webmanifest"><a href="/other">other</a>`})
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {
+		<-headers
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<a href="/third">third</a>`))
+	})
+	mux.HandleFunc("/third", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		close(third)
+	})
+	mux.HandleFunc("/app.webmanifest", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/manifest+json")
+		w.(http.Flusher).Flush()
+		close(headers)
+		// The other worker parses /other and fetches /third meanwhile,
+		// unless reading this body blocks it.
+		select {
+		case <-third:
+			w.Write([]byte(`{}`))
+		case <-time.After(2 * time.Second):
+			t.Error("crawl stalled while reading the manifest")
+		}
+	})
+	if problems := crawlSite(t, mux, "-checkManifest", "-concurrency", "2"); len(problems) != 0 {
+		t.Errorf("unexpected problems: %v", problems)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// PDF URI actions, as a literal or hex string: /URI (http://...) or
+// /URI <687474...>.
+var (
+	pdfURILiteralRe = regexp.MustCompile(`/URI\s*\(((?:\\.|[^\\)])*)\)`)
+	pdfURIHexRe     = regexp.MustCompile(`/URI\s*<([0-9A-Fa-f\s]*)>`)
+)
+
+// pdfLinks returns the targets of the URI link annotations of a PDF. Only
+// annotations in uncompressed objects are found, as there is no PDF
+// parser at hand to inflate object streams.
+func pdfLinks(pdf []byte) []string {
+	var links []string
+	for _, m := range pdfURILiteralRe.FindAllSubmatch(pdf, -1) {
+		links = append(links, unescapePDFString(string(m[1])))
+	}
+	for _, m := range pdfURIHexRe.FindAllSubmatch(pdf, -1) {
+		digits := strings.Join(strings.Fields(string(m[1])), "")
+		if len(digits)%2 == 1 {
+			digits += "0"
+		}
+		if b, err := hex.DecodeString(digits); err == nil {
+			links = append(links, string(b))
+		}
+	}
+	return links
+}
+
+// unescapePDFString undoes the backslash escapes of a PDF literal string
+// that can occur in URLs.
+func unescapePDFString(s string) string {
+	return strings.NewReplacer(`\(`, "(", `\)`, ")", `\\`, `\`, "\\\n", "", "\\\r", "").Replace(s)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPDFLinks(t *testing.T) {
+	// external.test, named in the fixture, is served by external.
+	external := httptest.NewServer(http.NotFoundHandler())
+	defer external.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/docs/manual.pdf">manual</a>`, "/docs/guide.html": ``})
+	mux.HandleFunc("/docs/manual.pdf", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "testdata/links.pdf")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	setFlags(t, "-root", srv.URL+"/", "-checkPdfLinks")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	dial := transports["http"].DialContext
+	transports["http"].DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == "external.test:80" {
+			addr = external.Listener.Addr().String()
+		}
+		return dial(ctx, network, addr)
+	}
+
+	problems := runCrawl()
+	pdf := fmt.Sprint([]string{*root + "docs/manual.pdf"})
+	for _, url := range []string{*root + "docs/missing.html", "http://external.test/retired(2019).html"} {
+		if p, ok := findProblem(problems, kindLink, url); !ok || p.Message != "404 Not Found" || fmt.Sprint(p.Sources) != pdf {
+			t.Errorf("want %s reported as a 404 from the PDF, got %v", url, problems)
+		}
+	}
+	if len(problems) != 2 {
+		t.Errorf("want only the 2 broken links of the PDF reported, got %v", problems)
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Problem kinds, named after what was being checked.
+const (
+	kindLink       = "link"       // <a href> target
+	kindImage      = "image"      // <img> or <picture> source
+	kindMedia      = "media"      // <video> or <audio> <source> target
+	kindFragment   = "fragment"   // #fragment of a link
+	kindCanonical  = "canonical"  // <link rel="canonical"> target
+	kindContent    = "content"    // body of a page
+	kindAmp        = "amp"        // <link rel="amphtml"> target
+	kindManifest   = "manifest"   // <link rel="manifest"> target
+	kindAlt        = "alt"        // alt text of an <img>
+	kindScheme     = "scheme"     // http and https versions of a URL
+	kindLinkHeader = "linkheader" // Link response header target
+	kindTitle      = "title"      // <title> of a page
+	kindRedirect   = "redirect"   // internal link that redirects
+	kindDNS        = "dns"        // name resolution of a host
+	kindCache      = "cache"      // caching headers of a response
+	kindPolicy     = "policy"     // path of an internal link target
+	kindEncoding   = "encoding"   // declared charset of a page
+	kindDomain     = "domain"     // external links to a registered domain
+	kindContact    = "contact"    // mailto: or tel: link
+	kindGraph      = "graph"      // internal links to a page
+	kindAsset      = "asset"      // <script> or stylesheet target
+	kindFavicon    = "favicon"    // <link rel="icon"> target, or /favicon.ico
+)
+
+// Problem severities. Only errors and critical problems affect the exit
+// code.
+const (
+	severityCritical = "critical" // internal server error, under -reportServerErrorsAsCritical
+	severityError    = "error"
+	severityWarning  = "warning"
+	severityInfo     = "info"
+)
+
+// A problem is something found to be wrong during the crawl.
+type problem struct {
+	Kind     string   `json:"kind"`
+	Severity string   `json:"severity"`
+	URL      string   `json:"url"`
+	Message  string   `json:"message,omitempty"`
+	Sources  []string `json:"sources,omitempty"` // pages referring to URL
+
+	linked bool // Sources are the pages linking to URL
+}
+
+func (p problem) String() string {
+	if p.Kind == kindFragment {
+		return fmt.Sprintf("Missing fragment for %s from %s", p.URL, formatSources(p.Sources))
+	}
+	label := "Error"
+	switch p.Severity {
+	case severityCritical:
+		label = "Critical"
+	case severityWarning:
+		label = "Warning"
+	case severityInfo:
+		label = "Note"
+	}
+	if len(p.Sources) == 0 && !p.linked {
+		return fmt.Sprintf("%s on %s: %s", label, p.URL, p.Message)
+	}
+	return fmt.Sprintf("%s on %s: %s (from %s)", label, p.URL, p.Message, formatSources(p.Sources))
+}
+
+// formatSources lists sources, trimmed to -maxSources.
+func formatSources(sources []string) string {
+	if *maxSources <= 0 || len(sources) <= *maxSources {
+		return fmt.Sprint(sources)
+	}
+	return fmt.Sprintf("%v and %d more", sources[:*maxSources], len(sources)-*maxSources)
+}
+
+// severityRank orders severities, higher meaning more severe. It returns
+// -1 for unknown severities.
+func severityRank(severity string) int {
+	switch severity {
+	case severityInfo:
+		return 0
+	case severityWarning:
+		return 1
+	case severityError:
+		return 2
+	case severityCritical:
+		return 3
+	}
+	return -1
+}
+
+// filterSeverity returns the problems at least as severe as min.
+func filterSeverity(problems []problem, min string) []problem {
+	var kept []problem
+	for _, p := range problems {
+		if severityRank(p.Severity) >= severityRank(min) {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
+
+// sortBySeverity sorts problems most severe first, keeping the order of
+// problems of the same severity.
+func sortBySeverity(problems []problem) {
+	sort.SliceStable(problems, func(i, j int) bool {
+		return severityRank(problems[i].Severity) > severityRank(problems[j].Severity)
+	})
+}
+
+// hasErrors reports whether any of problems is an error or worse.
+func hasErrors(problems []problem) bool {
+	for _, p := range problems {
+		if isError(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCritical reports whether any of problems is critical.
+func hasCritical(problems []problem) bool {
+	for _, p := range problems {
+		if p.Severity == severityCritical {
+			return true
+		}
+	}
+	return false
+}
+
+// isError reports whether p is an error or worse.
+func isError(p problem) bool {
+	return severityRank(p.Severity) >= severityRank(severityError)
+}
+
+// writeReport writes problems to w in the format selected by -format.
+func writeReport(w io.Writer, problems []problem) error {
+	if *format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if problems == nil {
+			problems = []problem{}
+		}
+		return enc.Encode(problems)
+	}
+	if *format == "github" {
+		return writeGitHub(w, problems)
+	}
+
+	if *groupBy == "source" {
+		return writeBySource(w, problems)
+	}
+	var critical, links, images, others, fragments, redirects []problem
+	for _, p := range problems {
+		switch {
+		case p.Severity == severityCritical:
+			critical = append(critical, p)
+		case p.Kind == kindRedirect:
+			redirects = append(redirects, p)
+		case p.Kind == kindFragment && *anchorRollup:
+			fragments = append(fragments, p)
+		case p.Kind == kindImage && *separateImgs:
+			images = append(images, p)
+		case !(p.Kind == kindLink && isError(p)) && *separateImgs:
+			others = append(others, p)
+		default:
+			links = append(links, p)
+		}
+	}
+	if err := writeSection(w, "Server errors", critical); err != nil {
+		return err
+	}
+	if *separateImgs {
+		if err := writeSection(w, "Broken links", links); err != nil {
+			return err
+		}
+		if err := writeSection(w, "Broken images", images); err != nil {
+			return err
+		}
+		if err := writeSection(w, "Other problems", others); err != nil {
+			return err
+		}
+	} else if err := writeProblems(w, links); err != nil {
+		return err
+	}
+	if err := writeFragments(w, fragments); err != nil {
+		return err
+	}
+	return writeSection(w, "Redirected internal links", redirects)
+}
+
+// writeFragments writes missing fragment problems under a heading, grouped
+// by the page lacking them, or nothing if there are none.
+func writeFragments(w io.Writer, problems []problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintln(w, "Missing fragments:"); err != nil {
+		return err
+	}
+	var page string
+	for _, p := range problems {
+		url, frag, _ := strings.Cut(p.URL, "#")
+		if url != page {
+			page = url
+			if _, err := fmt.Fprintf(w, "On %s:\n", page); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "  #%s from %s\n", frag, formatSources(p.Sources)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSection writes problems under a heading, or nothing if there are none.
+func writeSection(w io.Writer, heading string, problems []problem) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "%s:\n", heading); err != nil {
+		return err
+	}
+	return writeProblems(w, problems)
+}
+
+func writeProblems(w io.Writer, problems []problem) error {
+	for _, p := range problems {
+		if _, err := fmt.Fprintln(w, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBySource writes problems grouped by the page they were found on,
+// for fixing one page at a time. Problems about a page itself are listed
+// under that page.
+func writeBySource(w io.Writer, problems []problem) error {
+	type group struct {
+		labels []string            // in order of first appearance
+		items  map[string][]string // label -> items
+	}
+	groups := make(map[string]*group)
+	add := func(source, label, item string) {
+		g := groups[source]
+		if g == nil {
+			g = &group{items: make(map[string][]string)}
+			groups[source] = g
+		}
+		if _, ok := g.items[label]; !ok {
+			g.labels = append(g.labels, label)
+		}
+		g.items[label] = append(g.items[label], item)
+	}
+	for _, p := range problems {
+		if len(p.Sources) == 0 {
+			add(p.URL, problemLabel(p), p.Message)
+			continue
+		}
+		item := p.URL
+		if p.Message != "" {
+			item += " (" + p.Message + ")"
+		}
+		seen := make(map[string]bool)
+		for _, source := range p.Sources {
+			if !seen[source] {
+				seen[source] = true
+				add(source, problemLabel(p), item)
+			}
+		}
+	}
+
+	sources := make([]string, 0, len(groups))
+	for source := range groups {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	for _, source := range sources {
+		if _, err := fmt.Fprintf(w, "On %s:\n", source); err != nil {
+			return err
+		}
+		g := groups[source]
+		for _, label := range g.labels {
+			if _, err := fmt.Fprintf(w, "  %s: %s\n", label, strings.Join(g.items[label], ", ")); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// problemLabel names the category of p in the grouped report.
+func problemLabel(p problem) string {
+	switch {
+	case p.Severity == severityCritical:
+		return "server errors"
+	case p.Kind == kindFragment:
+		return "missing fragments"
+	case p.Severity == severityError && p.Kind == kindLink:
+		return "broken links"
+	case p.Severity == severityError && p.Kind == kindImage:
+		return "broken images"
+	}
+	if p.Severity == severityInfo {
+		return p.Kind + " notes"
+	}
+	return p.Kind + " " + p.Severity + "s"
+}
+
+// writeGitHub writes problems as GitHub Actions workflow commands, which
+// show up as annotations on the run.
+func writeGitHub(w io.Writer, problems []problem) error {
+	for _, p := range problems {
+		command := "error"
+		switch p.Severity {
+		case severityWarning:
+			command = "warning"
+		case severityInfo:
+			command = "notice"
+		}
+		if _, err := fmt.Fprintf(w, "::%s title=%s::%s\n", command, escapeProperty(p.Kind), escapeData(p.String())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property value of a workflow command.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
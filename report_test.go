@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSeparateImages(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/": `<a href="/gone">gone</a><img src="/missing.png" alt="">`,
+	}, "-reportBrokenImagesSeparately", "-checkTitles")
+	if _, ok := findProblem(problems, kindLink, "/gone"); !ok {
+		t.Errorf("broken link not reported as a link: %v", problems)
+	}
+	if _, ok := findProblem(problems, kindImage, "/missing.png"); !ok {
+		t.Errorf("broken image not reported as an image: %v", problems)
+	}
+
+	var out bytes.Buffer
+	if err := writeReport(&out, problems); err != nil {
+		t.Fatal(err)
+	}
+	links, images, ok := strings.Cut(out.String(), "Broken images:\n")
+	images, others, ok2 := strings.Cut(images, "Other problems:\n")
+	if !ok || !ok2 || !strings.HasPrefix(links, "Broken links:\n") {
+		t.Fatalf("report lacks the three sections:\n%s", out.String())
+	}
+	if !strings.Contains(links, "/gone") || strings.Contains(links, "/missing.png") {
+		t.Errorf("broken links section wrong:\n%s", links)
+	}
+	if !strings.Contains(images, "/missing.png") || strings.Contains(images, "/gone") {
+		t.Errorf("broken images section wrong:\n%s", images)
+	}
+	// The missing <title> is no broken link.
+	if !strings.Contains(others, "<title>") || strings.Contains(links, "<title>") {
+		t.Errorf("other problems section wrong:\n%s", others)
+	}
+}
+
+func TestGitHubFormat(t *testing.T) {
+	problems := crawlSite(t, site{"/": `<a href="/gone">gone</a>`}, "-format", "github")
+	var out bytes.Buffer
+	if err := writeReport(&out, problems); err != nil {
+		t.Fatal(err)
+	}
+	want := "::error title=link::Error on " + *root + "gone: 404 Not Found (from [" + *root + "])\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if got := escapeProperty("a:b,c%\n"); got != "a%3Ab%2Cc%25%0A" {
+		t.Errorf("escapeProperty = %q", got)
+	}
+}
+
+func TestGroupBySource(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":       `<a href="/broken">x</a>`,
+		"/broken": `<a href="/gone1">1</a><a href="/gone2">2</a>`,
+	}, "-groupBy", "source")
+	var out bytes.Buffer
+	if err := writeReport(&out, problems); err != nil {
+		t.Fatal(err)
+	}
+	want := "On " + *root + "broken:\n  broken links: " + *root + "gone1 (404 Not Found), " + *root + "gone2 (404 Not Found)\n"
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestMaxSources(t *testing.T) {
+	pages := site{"/": ``}
+	for i := 0; i < 7; i++ {
+		pages["/"] += fmt.Sprintf(`<a href="/page%d">%d</a>`, i, i)
+		pages[fmt.Sprintf("/page%d", i)] = `<footer><a href="/gone">gone</a></footer>`
+	}
+	problems := crawlSite(t, pages, "-maxSources", "3")
+	if len(problems) != 1 || len(problems[0].Sources) != 7 {
+		t.Fatalf("want /gone reported with 7 sources, got %v", problems)
+	}
+	if got := problems[0].String(); !strings.HasSuffix(got, "page2] and 4 more)") {
+		t.Errorf("source list not trimmed to 3: %s", got)
+	}
+}
+
+func TestMinSeverity(t *testing.T) {
+	pages := site{
+		"/":           `<a href="/gone">gone</a><img src="/spacer.gif" alt=""><img src="/chart.png">`,
+		"/spacer.gif": ``,
+		"/chart.png":  ``,
+	}
+	count := func(problems []problem) map[string]int {
+		n := make(map[string]int)
+		for _, p := range problems {
+			n[p.Severity]++
+		}
+		return n
+	}
+	if got := count(crawlSite(t, pages, "-checkAlt", "-checkTitles")); got[severityInfo] != 1 || got[severityWarning] != 1 || got[severityError] != 2 {
+		t.Errorf("problems by severity = %v", got)
+	}
+	t.Run("warning", func(t *testing.T) {
+		got := count(crawlSite(t, pages, "-checkAlt", "-checkTitles", "-minSeverity", "warning"))
+		if got[severityInfo] != 0 || got[severityWarning] != 1 || got[severityError] != 2 {
+			t.Errorf("problems by severity = %v", got)
+		}
+	})
+}
+
+func TestMissingFragmentRollup(t *testing.T) {
+	problems := crawlSite(t, site{
+		"/":    `<a href="/doc#a">a</a><a href="/doc#b">b</a><a href="/x">x</a><a href="/gone">gone</a>`,
+		"/x":   `<a href="/doc#a">a</a><a href="/doc#c">c</a>`,
+		"/doc": `<h2 id="c">C</h2>`,
+	}, "-reportLinksToDeletedAnchors")
+	var out bytes.Buffer
+	if err := writeReport(&out, problems); err != nil {
+		t.Fatal(err)
+	}
+	links, fragments, ok := strings.Cut(out.String(), "Missing fragments:\n")
+	if !ok || !strings.Contains(links, "/gone") || strings.Contains(links, "#") {
+		t.Fatalf("want the broken link and the fragments in their own sections:\n%s", out.String())
+	}
+	want := fmt.Sprintf("On %[1]sdoc:\n  #a from [%[1]s %[1]sx]\n  #b from [%[1]s]\n", *root)
+	if fragments != want {
+		t.Errorf("got fragments\n%s\nwant\n%s", fragments, want)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// statusExpectation says that URLs matching pattern are expected to
+// respond with code.
+type statusExpectation struct {
+	pattern *regexp.Regexp
+	code    int
+}
+
+// statusExpectations collects repeated -expectStatus flags.
+type statusExpectations []statusExpectation
+
+func (e *statusExpectations) String() string {
+	var s []string
+	for _, x := range *e {
+		s = append(s, fmt.Sprintf("%s=%d", x.pattern, x.code))
+	}
+	return strings.Join(s, " ")
+}
+
+// Set parses PATTERN=CODE. The last = separates the two, as the pattern
+// may itself contain one.
+func (e *statusExpectations) Set(s string) error {
+	i := strings.LastIndex(s, "=")
+	if i < 0 {
+		return fmt.Errorf("want PATTERN=CODE, got %q", s)
+	}
+	pattern, err := regexp.Compile(s[:i])
+	if err != nil {
+		return err
+	}
+	code, err := strconv.Atoi(s[i+1:])
+	if err != nil || code < 100 || code > 999 {
+		return fmt.Errorf("bad status code %q", s[i+1:])
+	}
+	*e = append(*e, statusExpectation{pattern, code})
+	return nil
+}
+
+// expected returns the status code expected for url by the first matching
+// expectation, if any.
+func (e statusExpectations) expected(url string) (int, bool) {
+	for _, x := range e {
+		if x.pattern.MatchString(url) {
+			return x.code, true
+		}
+	}
+	return 0, false
+}
+
+// statusSet collects the codes of -flagRedirectStatus, given comma
+// separated or by repeating the flag.
+type statusSet map[int]bool
+
+func (s statusSet) String() string {
+	codes := make([]int, 0, len(s))
+	for code := range s {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	var strs []string
+	for _, code := range codes {
+		strs = append(strs, strconv.Itoa(code))
+	}
+	return strings.Join(strs, ",")
+}
+
+func (s statusSet) Set(v string) error {
+	for _, c := range strings.Split(v, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(c))
+		if err != nil || code < 300 || code > 399 {
+			return fmt.Errorf("bad redirect status %q", c)
+		}
+		s[code] = true
+	}
+	return nil
+}
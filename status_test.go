@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestExpectStatus(t *testing.T) {
+	var status atomic.Int32
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/api/">api</a>`})
+	mux.HandleFunc("/api/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	})
+
+	status.Store(http.StatusUnauthorized)
+	if problems := crawlSite(t, mux, "-expectStatus", "/api/$=401"); len(problems) != 0 {
+		t.Errorf("expected 401 reported: %v", problems)
+	}
+	for _, code := range []int32{http.StatusInternalServerError, http.StatusOK} {
+		status.Store(code)
+		t.Run(http.StatusText(int(code)), func(t *testing.T) {
+			// Rechecking must not let the unexpected status pass either.
+			problems := crawlSite(t, mux, "-expectStatus", "/api/$=401", "-recheckFailures")
+			if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/api/") || !strings.Contains(problems[0].Message, "expected 401") {
+				t.Errorf("want the unexpected status of /api/ reported, got %v", problems)
+			}
+		})
+	}
+}
+
+func TestFlagRedirectStatus(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/moved">moved</a><a href="/temp">temp</a>`, "/new": ``})
+	mux.Handle("/moved", http.RedirectHandler("/new", http.StatusMovedPermanently))
+	mux.Handle("/temp", http.RedirectHandler("/new", http.StatusFound))
+	problems := crawlSite(t, mux, "-flagRedirectStatus", "302")
+	if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/temp") || problems[0].Message != "302 Found redirect to "+*root+"new" {
+		t.Errorf("want only the 302 reported, got %v", problems)
+	}
+}
+
+func TestServerErrorsAsCritical(t *testing.T) {
+	fail := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+	external := httptest.NewServer(fail)
+	defer external.Close()
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/broken">broken</a><a href="/gone">gone</a><a href="` + external.URL + `/x">external</a>`})
+	mux.Handle("/broken", fail)
+	for _, tc := range []struct {
+		flag     string
+		critical int
+		exit     int
+	}{{"-reportServerErrorsAsCritical=false", 0, exitProblems}, {"-reportServerErrorsAsCritical", 1, exitCritical}} {
+		t.Run(tc.flag, func(t *testing.T) {
+			problems := crawlSite(t, mux, tc.flag)
+			if len(problems) != 3 {
+				t.Fatalf("want 3 problems, got %v", problems)
+			}
+			var critical []problem
+			for _, p := range problems {
+				if p.Severity == severityCritical {
+					critical = append(critical, p)
+				}
+			}
+			if len(critical) != tc.critical || tc.critical > 0 && !strings.HasSuffix(critical[0].URL, "/broken") {
+				t.Errorf("want only the internal 500 critical, got %v", critical)
+			}
+			if got := exitCode(problems); got != tc.exit {
+				t.Errorf("exit code %d, want %d", got, tc.exit)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transports holds a separate transport, and so a separate connection pool,
+// per URL scheme.
+var transports = map[string]*http.Transport{}
+
+// setupTransports creates the per-scheme transports. limits is a
+// -concurrencyPerScheme value such as "http=2,https=8", capping the number
+// of connections per host for each scheme.
+func setupTransports(limits string) error {
+	schemeConns, err := parseSchemeLimits(limits)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: *keepAlive,
+	}
+	for _, scheme := range []string{"http", "https"} {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.DialContext = dialer.DialContext
+		if *dnsTimeout > 0 || *slowDNS {
+			t.DialContext = resolvingDialContext(dialer)
+		}
+		t.IdleConnTimeout = *idleConnTime
+		t.MaxConnsPerHost = *maxConns
+		if n, ok := schemeConns[scheme]; ok {
+			t.MaxConnsPerHost = n
+		}
+		t.MaxResponseHeaderBytes = *maxHeaderSize
+		transports[scheme] = t
+	}
+	return nil
+}
+
+func parseSchemeLimits(limits string) (map[string]int, error) {
+	maxConns := make(map[string]int)
+	for _, limit := range strings.Split(limits, ",") {
+		if limit = strings.TrimSpace(limit); limit == "" {
+			continue
+		}
+		scheme, n, ok := strings.Cut(limit, "=")
+		if !ok {
+			return nil, fmt.Errorf("bad scheme limit %q, want scheme=N", limit)
+		}
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme != "http" && scheme != "https" {
+			return nil, fmt.Errorf("bad scheme limit %q: unknown scheme %q", limit, scheme)
+		}
+		max, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil || max < 0 {
+			return nil, fmt.Errorf("bad scheme limit %q: %q is not a connection count", limit, n)
+		}
+		maxConns[scheme] = max
+	}
+	return maxConns, nil
+}
+
+// transportFor returns the transport for requests with the given scheme.
+func transportFor(scheme string) http.RoundTripper {
+	if t, ok := transports[scheme]; ok {
+		return t
+	}
+	return http.DefaultTransport
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// peakCounter counts the requests a handler is serving at once.
+type peakCounter struct {
+	mu        sync.Mutex
+	now, peak int
+}
+
+func (c *peakCounter) wrap(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.Lock()
+		c.now++
+		c.peak = max(c.peak, c.now)
+		c.mu.Unlock()
+		defer func() {
+			c.mu.Lock()
+			c.now--
+			c.mu.Unlock()
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (c *peakCounter) max() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.peak
+}
+
+func TestConcurrencyPerScheme(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/plain")
+	})
+	var httpsConns peakCounter
+	external := httptest.NewTLSServer(httpsConns.wrap(slow))
+	defer external.Close()
+
+	var httpConns peakCounter
+	page := ""
+	for i := 0; i < 4; i++ {
+		page += fmt.Sprintf(`<a href="/page/%d">x</a><a href="%s/page/%d">x</a>`, i, external.URL, i)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": page})
+	mux.Handle("/page/", slow)
+	srv := httptest.NewServer(httpConns.wrap(mux))
+	defer srv.Close()
+
+	setFlags(t, "-root", srv.URL+"/", "-concurrency", "8", "-concurrencyPerScheme", "http=1,https=3")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	if got := transports["http"].MaxConnsPerHost; got != 1 {
+		t.Errorf("http MaxConnsPerHost = %d, want 1", got)
+	}
+	if got := transports["https"].MaxConnsPerHost; got != 3 {
+		t.Errorf("https MaxConnsPerHost = %d, want 3", got)
+	}
+	transports["https"].TLSClientConfig = external.Client().Transport.(*http.Transport).TLSClientConfig
+	if problems := runCrawl(); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if got := httpConns.max(); got != 1 {
+		t.Errorf("%d concurrent http requests, want 1", got)
+	}
+	if got := httpsConns.max(); got < 2 || got > 3 {
+		t.Errorf("%d concurrent https requests, want 2 or 3", got)
+	}
+}
+
+func TestOversizedHeaders(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/bomb">bomb</a><a href="/ok">ok</a>`, "/ok": ``})
+	mux.HandleFunc("/bomb", func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 100; i++ {
+			w.Header().Add(fmt.Sprintf("X-Padding-%d", i), strings.Repeat("x", 100))
+		}
+	})
+	problems := crawlSite(t, mux, "-maxResponseHeaderBytes", "4096")
+	if len(problems) != 1 || !strings.HasSuffix(problems[0].URL, "/bomb") || !strings.Contains(problems[0].Message, "header") {
+		t.Errorf("want only /bomb reported for its headers, got %v", problems)
+	}
+}
+
+func TestDrainBodyReusesConnections(t *testing.T) {
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(strings.Repeat("<p>unread</p>", 2500)))
+	}))
+	defer external.Close()
+	page := ""
+	for i := 0; i < 10; i++ {
+		page += fmt.Sprintf(`<a href="%s/%d">%d</a>`, external.URL, i, i)
+	}
+	srv := httptest.NewServer(site{"/": page})
+	defer srv.Close()
+
+	setFlags(t, "-root", srv.URL+"/")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	var conns atomic.Int32
+	dial := transports["http"].DialContext
+	transports["http"].DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == external.Listener.Addr().String() {
+			conns.Add(1)
+		}
+		return dial(ctx, network, addr)
+	}
+	if problems := runCrawl(); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("%d connections to the external host, want 1", got)
+	}
+}
+
+func TestConnectionLimits(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/plain")
+	})
+	var conns peakCounter
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/p/1">1</a><a href="/p/2">2</a><a href="/p/3">3</a><a href="/p/4">4</a>`})
+	mux.Handle("/p/", slow)
+	srv := httptest.NewServer(conns.wrap(mux))
+	defer srv.Close()
+
+	setFlags(t, "-root", srv.URL+"/", "-concurrency", "4", "-maxConnsPerHost", "1", "-idleConnTimeout", "5s")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	for scheme, tr := range transports {
+		if tr.IdleConnTimeout != 5*time.Second {
+			t.Errorf("%s IdleConnTimeout = %v, want 5s", scheme, tr.IdleConnTimeout)
+		}
+		if tr.MaxConnsPerHost != 1 {
+			t.Errorf("%s MaxConnsPerHost = %d, want 1", scheme, tr.MaxConnsPerHost)
+		}
+	}
+	if problems := runCrawl(); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if got := conns.max(); got != 1 {
+		t.Errorf("%d concurrent requests, want 1", got)
+	}
+}
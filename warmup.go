@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// warmupRoot fetches the root, following redirects, and returns an error
+// explaining why a crawl from it would be pointless: the root being
+// unreachable, denying access, or not being an HTML page.
+func warmupRoot(root string) error {
+	url := root
+	for hops := 0; ; hops++ {
+		res, err := fetch(url)
+		if err != nil {
+			return fmt.Errorf("%s is unreachable: %v", url, err)
+		}
+		closeBody(res)
+		switch {
+		case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+			return fmt.Errorf("%s responds %s, check the credentials or -cookieFile", url, res.Status)
+		case res.StatusCode/100 == 3:
+			next, err := res.Location()
+			if err != nil {
+				return fmt.Errorf("%s redirects to a bad location: %v", url, err)
+			}
+			if loginRe != nil && loginRe.MatchString(next.String()) {
+				return fmt.Errorf("%s redirects to the login page %s, check the credentials or -cookieFile", url, next)
+			}
+			if hops >= *maxRedirects {
+				return fmt.Errorf("%s redirects more than %d times", root, *maxRedirects)
+			}
+			url = next.String()
+			continue
+		case res.StatusCode/100 != 2:
+			return fmt.Errorf("%s responds %s", url, res.Status)
+		}
+		if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			return fmt.Errorf("%s has Content-Type %q, not an HTML page", url, ct)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWarmup(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/{$}", http.RedirectHandler("/home", http.StatusFound))
+	mux.HandleFunc("/home", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "log in first", http.StatusUnauthorized)
+	})
+	mux.Handle("/open/", site{"/open/": ``, "/open/data.json": `{}`})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	setFlags(t)
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		"/":               srv.URL + "/home responds 401 Unauthorized, check the credentials or -cookieFile",
+		"/open/data.json": srv.URL + `/open/data.json has Content-Type "application/json", not an HTML page`,
+		"/open/":          "",
+	} {
+		stateMu.Lock()
+		err := warmupRoot(srv.URL + path)
+		stateMu.Unlock()
+		if got := fmt.Sprint(err); want != "" && got != want || want == "" && err != nil {
+			t.Errorf("warmup of %s: got %v, want %q", path, err, want)
+		}
+	}
+}
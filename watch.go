@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watchLoop crawls every interval, forever, writing the report whenever
+// the problems found differ from those of the previous crawl. The first
+// report is always written.
+func watchLoop(interval time.Duration) {
+	var last string
+	for run := 0; ; run++ {
+		if run > 0 {
+			time.Sleep(interval)
+		}
+		var err error
+		if last, err = watchRun(os.Stdout, run, last); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// watchRun does crawl number run of watchLoop, writing its report to w if
+// it is the first or its problems differ from last, the problemSet of the
+// previous crawl. It returns the problemSet of this crawl.
+func watchRun(w io.Writer, run int, last string) (string, error) {
+	if run > 0 {
+		resetCrawl()
+	}
+	report := runCrawl()
+	key := problemSet(report)
+	if run == 0 || key != last {
+		if run > 0 {
+			log.Printf("Problems changed, %d now", len(report))
+		}
+		if err := writeReport(w, report); err != nil {
+			return key, err
+		}
+	}
+	return key, nil
+}
+
+// problemSet identifies the set of problems, ignoring their order and
+// sources, for comparing the results of two crawls.
+func problemSet(problems []problem) string {
+	keys := make([]string, len(problems))
+	for i, p := range problems {
+		keys[i] = fmt.Sprintf("%s\x00%s\x00%s\x00%s", p.Kind, p.Severity, p.URL, p.Message)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, "\n")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchCycles(t *testing.T) {
+	var broken atomic.Bool
+	mux := http.NewServeMux()
+	mux.Handle("/", site{"/": `<a href="/a">a</a>`})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		if broken.Load() {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	setFlags(t, "-root", srv.URL+"/", "-format", "json", "-throttleOnErrorRate", "0.5")
+	if err := configure(); err != nil {
+		t.Fatal(err)
+	}
+
+	var last string
+	for run, tc := range []struct {
+		broken bool
+		want   string // in the report written, if any
+	}{
+		{false, "[]"},
+		{false, ""},
+		{true, `"url": "` + srv.URL + `/a"`},
+		{true, ""},
+	} {
+		broken.Store(tc.broken)
+		if run > 0 {
+			// A slowdown must not outlast the crawl it was for.
+			throttleDelay = maxThrottleDelay
+		}
+		var out bytes.Buffer
+		start := time.Now()
+		var err error
+		if last, err = watchRun(&out, run, last); err != nil {
+			t.Fatal(err)
+		}
+		if time.Since(start) >= maxThrottleDelay {
+			t.Errorf("run %d: throttled by the previous run", run)
+		}
+		if got := out.String(); tc.want == "" && got != "" || !strings.Contains(got, tc.want) {
+			t.Errorf("run %d: got report %q, want one with %q", run, got, tc.want)
+		}
+	}
+}